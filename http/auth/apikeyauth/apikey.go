@@ -2,11 +2,14 @@ package apikeyauth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/lockout"
 )
 
 // APIKeyAuthenticator receives a string, and is expected to return an object
@@ -20,7 +23,25 @@ type APIKeyAuthenticator func(key string) (interface{}, error)
 func NewAPIKeyAuthenticator(keyname, contextKey string, failFn auth.ErrorHandler, authFn APIKeyAuthenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			req, err := checkAPIKey(keyname, contextKey, r, authFn)
+			req, err := checkAPIKey(keyname, contextKey, r, authFn, nil)
+			if err != nil {
+				failFn(rw, req, err)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// NewAPIKeyAuthenticatorWithLockout creates an api key authentication middleware, as
+// with `NewAPIKeyAuthenticator`, which also records failed attempts in the
+// tracker.  Failures are tracked per client IP and per presented key.  Once
+// either is locked out, further attempts fail with `auth.ErrTooManyRequests`
+// without calling the authenticator function.
+func NewAPIKeyAuthenticatorWithLockout(keyname, contextKey string, failFn auth.ErrorHandler, authFn APIKeyAuthenticator, tracker *lockout.Tracker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := checkAPIKey(keyname, contextKey, r, authFn, tracker)
 			if err != nil {
 				failFn(rw, req, err)
 				return
@@ -35,7 +56,7 @@ func NewAPIKeyAuthenticator(keyname, contextKey string, failFn auth.ErrorHandler
 // the api key, and store a returned object in the request context.
 func NewAPIKeyAuthenticatorMiddleware(keyname, contextKey string, failFn auth.ErrorHandler, authFn APIKeyAuthenticator) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
 	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-		req, err := checkAPIKey(keyname, contextKey, r, authFn)
+		req, err := checkAPIKey(keyname, contextKey, r, authFn, nil)
 		if err != nil {
 			failFn(rw, req, err)
 			return
@@ -44,7 +65,21 @@ func NewAPIKeyAuthenticatorMiddleware(keyname, contextKey string, failFn auth.Er
 	}
 }
 
-func checkAPIKey(keyname string, contextKey string, r *http.Request, authFn APIKeyAuthenticator) (*http.Request, error) {
+// NewAPIKeyAuthenticatorWithLockoutMiddleware creates a negroni-style api key
+// authentication middleware which records failed attempts in the tracker.  See
+// `NewAPIKeyAuthenticatorWithLockout` for details.
+func NewAPIKeyAuthenticatorWithLockoutMiddleware(keyname, contextKey string, failFn auth.ErrorHandler, authFn APIKeyAuthenticator, tracker *lockout.Tracker) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		req, err := checkAPIKey(keyname, contextKey, r, authFn, tracker)
+		if err != nil {
+			failFn(rw, req, err)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+func checkAPIKey(keyname string, contextKey string, r *http.Request, authFn APIKeyAuthenticator, tracker *lockout.Tracker) (*http.Request, error) {
 	authHeader := r.Header.Get("Authorization")
 
 	// no auth header, continue on
@@ -58,15 +93,47 @@ func checkAPIKey(keyname string, contextKey string, r *http.Request, authFn APIK
 		return r, nil
 	}
 
+	// refuse the attempt early if the client or key has failed too many times
+	key := authHeaderParts[1]
+	var lockoutKeys []string
+	if tracker != nil {
+		lockoutKeys = []string{"ip:" + auth.RemoteIP(r), "key:" + keyHash(key)}
+		if wait := tracker.Check(lockoutKeys...); wait > 0 {
+			err := auth.NewErrTooManyRequests(wait)
+			auth.NotifyAuthenticationFailed(r, err)
+			return r, err
+		}
+	}
+
 	// validate the api key, and get something back
-	obj, err := authFn(authHeaderParts[1])
+	obj, err := authFn(key)
+	if tracker != nil {
+		if err == auth.ErrAuthenticationRequired {
+			tracker.Fail(lockoutKeys...)
+		} else if err == nil {
+			// only the key is reset, so a client can't clear failures for
+			// its IP by interleaving attempts with a valid key of its own
+			tracker.Reset(lockoutKeys[1])
+		}
+	}
+	if err == nil && obj == nil {
+		err = errors.New("authenticator returned nil, should return error instead")
+	}
 	if err != nil {
+		auth.NotifyAuthenticationFailed(r, err)
 		return r, err
 	}
-	if obj == nil {
-		return r, errors.New("authenticator returned nil, should return error instead")
-	}
 
 	// return new req w/ altered context
-	return r.WithContext(context.WithValue(r.Context(), contextKey, obj)), nil
+	req := r.WithContext(context.WithValue(r.Context(), contextKey, obj))
+	auth.NotifyAuthenticated(req, obj)
+	return req, nil
+}
+
+// keyHash identifies a presented key for lockout tracking, without keeping the
+// key itself in memory.  The whole key is hashed, as keys commonly share a
+// prefix such as "sk_live_".
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
 }
@@ -6,8 +6,10 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/lockout"
 	"github.com/stretchr/testify/require"
 )
 
@@ -77,6 +79,93 @@ func TestNewAPIKeyAuthenticator(t *testing.T) {
 	})
 }
 
+func TestNewAPIKeyAuthenticatorWithLockout(t *testing.T) {
+	calls := 0
+	countingAuthenticator := func(key string) (interface{}, error) {
+		calls++
+		return authenticateApiKey(key)
+	}
+	tracker := lockout.NewTracker(lockout.Policy{MaxFailures: 2, Window: time.Minute, LockoutDuration: time.Minute})
+	authenticate := NewAPIKeyAuthenticatorWithLockout("Key", "ApiClient", auth.StandardErrorHandler, countingAuthenticator, tracker)
+	var receivedReq http.Request
+	ts := httptest.NewServer(authenticate(createTestHandler(t, &receivedReq)))
+	defer ts.Close()
+
+	call := func(key string) *http.Response {
+		r, _ := http.NewRequest("GET", "", nil)
+		r.Header.Set("Authorization", "Key "+key)
+		return runReq(t, ts, r)
+	}
+
+	// good keys don't count towards lockout
+	require.Equal(t, 200, call("good-api-key").StatusCode)
+	require.Equal(t, 401, call("bad-api-key-1").StatusCode)
+	require.Equal(t, 200, call("good-api-key").StatusCode)
+	require.Equal(t, 401, call("bad-api-key-2").StatusCode)
+	require.Equal(t, 4, calls)
+
+	// the client is now locked out, and the authenticator isn't called
+	res := call("good-api-key")
+	require.Equal(t, 429, res.StatusCode)
+	require.Equal(t, "60", res.Header.Get("Retry-After"))
+	require.Equal(t, "Too many requests", readRes(t, res))
+	require.Equal(t, 4, calls)
+}
+
+func TestNewAPIKeyAuthenticatorWithLockoutSharedPrefix(t *testing.T) {
+	authFn := func(key string) (interface{}, error) {
+		if key == "sk_live_good" {
+			return auth.NewBasicApiClient(key, []string{}), nil
+		}
+		return nil, auth.ErrAuthenticationRequired
+	}
+	tracker := lockout.NewTracker(lockout.Policy{MaxFailures: 2, Window: time.Minute, LockoutDuration: time.Minute})
+	authenticate := NewAPIKeyAuthenticatorWithLockout("Key", "ApiClient", auth.StandardErrorHandler, authFn, tracker)
+	h := authenticate(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	call := func(ip, key string) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Authorization", "Key "+key)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	// an attacker guessing keys with the same prefix locks out only itself
+	require.Equal(t, 401, call("10.0.0.1", "sk_live_bad1"))
+	require.Equal(t, 401, call("10.0.0.1", "sk_live_bad2"))
+	require.Equal(t, 429, call("10.0.0.1", "sk_live_bad3"))
+	require.Equal(t, 200, call("10.0.0.2", "sk_live_good"))
+}
+
+func TestNewAPIKeyAuthenticatorObserver(t *testing.T) {
+	var authenticated []string
+	var failed []error
+	observe := auth.NewObserverInjector(auth.ObserverFuncs{
+		Authenticated: func(r *http.Request, principal interface{}) {
+			// the principal is already in the request context
+			require.Equal(t, principal, r.Context().Value("ApiClient"))
+			authenticated = append(authenticated, principal.(auth.Authenticator).AuthenticationID())
+		},
+		AuthenticationFailed: func(r *http.Request, err error) {
+			failed = append(failed, err)
+		},
+	})
+	authenticate := NewAPIKeyAuthenticator("Key", "ApiClient", auth.StandardErrorHandler, authenticateApiKey)
+	h := observe(authenticate(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})))
+
+	for _, key := range []string{"good-api-key", "bad-api-key", ""} {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Key "+key)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	require.Equal(t, []string{"good-api-key"}, authenticated)
+	require.Equal(t, []error{auth.ErrAuthenticationRequired}, failed)
+}
+
 func runReq(t *testing.T, ts *httptest.Server, req *http.Request) *http.Response {
 	u, err := url.Parse(ts.URL)
 	if err != nil {
@@ -0,0 +1,332 @@
+// Package assertion propagates authenticated principals between internal services.
+// A gateway which has authenticated a request mints a compact, signed assertion
+// describing the principal, and downstream services verify it instead of
+// re-authenticating the original credentials.
+//
+// Assertions are formatted as `v1.<payload>.<signature>`, where the payload is
+// base64url encoded JSON, and the signature is a base64url encoded HMAC SHA-256
+// of the version and payload.  They should be short lived, and only sent between
+// trusted services.
+package assertion
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+// Header is the request header assertions are sent in
+const Header = "X-Principal-Assertion"
+
+const version = "v1"
+
+var (
+	// ErrInvalidAssertion is returned when an assertion is malformed, its
+	// signature is invalid, its issuer is unknown, or it was minted for another
+	// audience
+	ErrInvalidAssertion = errors.New("invalid principal assertion")
+	// ErrAssertionExpired is returned when an assertion has expired
+	ErrAssertionExpired = errors.New("principal assertion expired")
+	// ErrPermissionsNotListed is returned when minting an assertion for a
+	// principal which implements `auth.Authorizer`, but not
+	// `auth.PermissionLister`, as its permissions can't be passed on
+	ErrPermissionsNotListed = errors.New("principal permissions can't be listed")
+)
+
+var b64 = base64.RawURLEncoding
+
+// Assertion describes an authenticated principal
+type Assertion struct {
+	Subject     string   `json:"sub"`
+	Permissions []string `json:"perms,omitempty"`
+	TenantID    string   `json:"tid,omitempty"`
+	Actor       string   `json:"act,omitempty"`
+	Issuer      string   `json:"iss"`
+	Audience    string   `json:"aud"`
+	ExpiresAt   int64    `json:"exp"`
+}
+
+// Principal is the object stored in the request context by the assertion
+// authenticator.  It implements the `auth.Authenticator`, `auth.Authorizer`,
+// `auth.TenantAuthenticator`, `auth.PermissionLister` and `auth.Expirer`
+// interfaces.
+type Principal struct {
+	assertion Assertion
+}
+
+func (p Principal) AuthenticationID() string {
+	return p.assertion.Subject
+}
+
+func (p Principal) HasPermission(perm string) (bool, error) {
+	for _, granted := range p.assertion.Permissions {
+		if granted == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p Principal) Permissions() []string {
+	return p.assertion.Permissions
+}
+
+func (p Principal) TenantID() string {
+	return p.assertion.TenantID
+}
+
+func (p Principal) ExpiresAt() time.Time {
+	return time.Unix(p.assertion.ExpiresAt, 0)
+}
+
+// ActorID returns the id of the principal acting on behalf of the subject, if the
+// assertion was minted for an impersonated session
+func (p Principal) ActorID() string {
+	return p.assertion.Actor
+}
+
+// Issuer returns the service which minted the assertion
+func (p Principal) Issuer() string {
+	return p.assertion.Issuer
+}
+
+// Signer mints assertions for principals
+type Signer struct {
+	// Issuer identifies the service minting assertions
+	Issuer string
+	// Audience identifies the service assertions are minted for, so that they
+	// can't be replayed to other services sharing the key
+	Audience string
+	// Key shared with services verifying assertions
+	Key []byte
+	// TTL is how long assertions are valid for
+	TTL time.Duration
+}
+
+// NewSigner returns a Signer minting assertions for the audience, valid for 30
+// seconds
+func NewSigner(issuer, audience string, key []byte) *Signer {
+	return &Signer{Issuer: issuer, Audience: audience, Key: key, TTL: 30 * time.Second}
+}
+
+// Mint returns a signed assertion for the principal, which must implement
+// `auth.Authenticator`.  Permissions are included if it implements
+// `auth.Authorizer`, in which case it must also implement
+// `auth.PermissionLister`, and its tenant if it implements
+// `auth.TenantAuthenticator`.  For impersonated sessions the id of the actor is
+// included, and the assertion never outlives the principal or actor's expiry.
+func (s *Signer) Mint(principal interface{}) (string, error) {
+	client, ok := principal.(auth.Authenticator)
+	if !ok || client.AuthenticationID() == "" {
+		return "", auth.ErrAuthenticationRequired
+	}
+	a := Assertion{
+		Subject:  client.AuthenticationID(),
+		Issuer:   s.Issuer,
+		Audience: s.Audience,
+	}
+	if lister, ok := principal.(auth.PermissionLister); ok {
+		a.Permissions = lister.Permissions()
+	} else if _, ok := principal.(auth.Authorizer); ok {
+		return "", ErrPermissionsNotListed
+	}
+	if tenant, ok := principal.(auth.TenantAuthenticator); ok {
+		a.TenantID = tenant.TenantID()
+	}
+
+	expires := time.Now().Add(s.TTL)
+	expires = clampExpiry(expires, principal)
+	switch p := principal.(type) {
+	case impersonation:
+		actor, ok := p.Actor().(auth.Authenticator)
+		if !ok || actor.AuthenticationID() == "" {
+			return "", auth.ErrAuthenticationRequired
+		}
+		a.Actor = actor.AuthenticationID()
+		expires = clampExpiry(expires, p.Actor())
+	case actorIDer:
+		a.Actor = p.ActorID()
+	}
+	a.ExpiresAt = expires.Unix()
+
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	input := version + "." + b64.EncodeToString(payload)
+	return input + "." + b64.EncodeToString(sign(s.Key, input)), nil
+}
+
+// impersonation is implemented by `auth.Impersonation`
+type impersonation interface {
+	Actor() interface{}
+}
+
+// actorIDer is implemented by Principal, so that assertions can be passed on
+// through further services
+type actorIDer interface {
+	ActorID() string
+}
+
+// clampExpiry returns the earlier of the expiry and that of the object, if it
+// implements `auth.Expirer`
+func clampExpiry(expires time.Time, obj interface{}) time.Time {
+	if e, ok := obj.(auth.Expirer); ok {
+		if at := e.ExpiresAt(); !at.IsZero() && at.Before(expires) {
+			return at
+		}
+	}
+	return expires
+}
+
+// Verifier verifies assertions minted by known issuers
+type Verifier struct {
+	// Audience identifies the verifying service.  Assertions must have been
+	// minted for it.
+	Audience string
+	// Keys shared with each known issuer
+	Keys map[string][]byte
+	// Leeway allowed for clock skew when checking expiry
+	Leeway time.Duration
+}
+
+// NewVerifier returns a Verifier for assertions minted for the audience by the
+// issuers, using the key shared with each.
+func NewVerifier(audience string, keys map[string][]byte) *Verifier {
+	return &Verifier{Audience: audience, Keys: keys}
+}
+
+// Verify returns the contents of a valid assertion
+func (v *Verifier) Verify(value string) (*Assertion, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != version {
+		return nil, ErrInvalidAssertion
+	}
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidAssertion
+	}
+
+	// the issuer is read before the signature is checked, but is only used to
+	// choose the key
+	var a Assertion
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return nil, ErrInvalidAssertion
+	}
+	key, ok := v.Keys[a.Issuer]
+	if !ok || !hmac.Equal(sign(key, parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidAssertion
+	}
+	if a.Subject == "" || a.Audience == "" || a.Audience != v.Audience {
+		return nil, ErrInvalidAssertion
+	}
+	if time.Now().Add(-v.Leeway).Unix() >= a.ExpiresAt {
+		return nil, ErrAssertionExpired
+	}
+	return &a, nil
+}
+
+func sign(key []byte, input string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(input))
+	return mac.Sum(nil)
+}
+
+// NewAssertionAuthenticator creates a middleware that will detect an incoming
+// assertion header, verify it, and store a Principal in the request context at
+// the specified key.  Invalid or expired assertions result in
+// `auth.ErrAuthenticationRequired`.
+func NewAssertionAuthenticator(contextKey string, failFn auth.ErrorHandler, verifier *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := checkAssertion(contextKey, r, verifier)
+			if err != nil {
+				failFn(rw, req, err)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// NewAssertionAuthenticatorMiddleware creates a negroni-style middleware that will
+// detect an incoming assertion header, verify it, and store a Principal in the
+// request context at the specified key.
+func NewAssertionAuthenticatorMiddleware(contextKey string, failFn auth.ErrorHandler, verifier *Verifier) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		req, err := checkAssertion(contextKey, r, verifier)
+		if err != nil {
+			failFn(rw, req, err)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+func checkAssertion(contextKey string, r *http.Request, verifier *Verifier) (*http.Request, error) {
+	value := r.Header.Get(Header)
+	if value == "" {
+		return r, nil
+	}
+	a, err := verifier.Verify(value)
+	if err != nil {
+		return r, auth.ErrAuthenticationRequired
+	}
+	return r.WithContext(context.WithValue(r.Context(), contextKey, Principal{*a})), nil
+}
+
+// Transport is an `http.RoundTripper` which mints an assertion for the principal
+// found in the context of outgoing requests.  Requests without a principal are
+// sent without an assertion.  The outgoing request must carry the context of the
+// incoming request, for example via `http.Request.WithContext`.
+type Transport struct {
+	// Signer mints assertions
+	Signer *Signer
+	// ContextKey the principal is stored at
+	ContextKey string
+	// Base is the RoundTripper used to make requests, defaults to
+	// `http.DefaultTransport`
+	Base http.RoundTripper
+}
+
+// RoundTrip implements `http.RoundTripper`
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	principal := req.Context().Value(t.ContextKey)
+	if principal == nil {
+		return base.RoundTrip(req)
+	}
+
+	value, err := t.Signer.Mint(principal)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// round trippers must not modify the request they are given
+	r2 := new(http.Request)
+	*r2 = *req
+	r2.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r2.Header[k] = append([]string(nil), v...)
+	}
+	r2.Header.Set(Header, value)
+	return base.RoundTrip(r2)
+}
@@ -0,0 +1,172 @@
+package assertion
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintVerify(t *testing.T) {
+	signer := NewSigner("gateway", "users", []byte("gateway-key"))
+	verifier := NewVerifier("users", map[string][]byte{"gateway": []byte("gateway-key")})
+
+	value, err := signer.Mint(auth.NewBasicTenantClient("user-1", "acme", nil))
+	require.Nil(t, err)
+	a, err := verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, "user-1", a.Subject)
+	require.Equal(t, "acme", a.TenantID)
+	require.Equal(t, "gateway", a.Issuer)
+	require.Equal(t, "users", a.Audience)
+
+	value, err = signer.Mint(auth.NewBasicApiClient("client-1", []string{"users.read"}))
+	require.Nil(t, err)
+	a, err = verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, []string{"users.read"}, a.Permissions)
+
+	// principals must be authenticated
+	_, err = signer.Mint(nil)
+	require.Equal(t, auth.ErrAuthenticationRequired, err)
+
+	t.Run("invalid assertions", func(t *testing.T) {
+		parts := strings.Split(value, ".")
+		other, _ := NewSigner("gateway", "users", []byte("wrong-key")).Mint(auth.NewBasicApiClient("client-1", nil))
+		unknown, _ := NewSigner("other", "users", []byte("gateway-key")).Mint(auth.NewBasicApiClient("client-1", nil))
+		misdirected, _ := NewSigner("gateway", "billing", []byte("gateway-key")).Mint(auth.NewBasicApiClient("client-1", nil))
+		unaddressed, _ := NewSigner("gateway", "", []byte("gateway-key")).Mint(auth.NewBasicApiClient("client-1", nil))
+		for _, bad := range []string{
+			"",
+			"v2." + parts[1] + "." + parts[2],
+			parts[0] + "." + strings.Split(other, ".")[1] + "." + parts[2],
+			other,
+			unknown,
+			misdirected,
+			unaddressed,
+		} {
+			_, err := verifier.Verify(bad)
+			require.Equal(t, ErrInvalidAssertion, err, bad)
+		}
+	})
+
+	t.Run("expired assertions", func(t *testing.T) {
+		expiring := NewSigner("gateway", "users", []byte("gateway-key"))
+		expiring.TTL = -time.Second
+		value, _ := expiring.Mint(auth.NewBasicApiClient("client-1", nil))
+		_, err := verifier.Verify(value)
+		require.Equal(t, ErrAssertionExpired, err)
+		verifier.Leeway = 5 * time.Second
+		_, err = verifier.Verify(value)
+		require.Nil(t, err)
+	})
+}
+
+func TestMintPrincipals(t *testing.T) {
+	signer := NewSigner("gateway", "users", []byte("gateway-key"))
+	verifier := NewVerifier("users", map[string][]byte{"gateway": []byte("gateway-key")})
+
+	// principals whose permissions can't be listed are refused, rather than
+	// passed on without them
+	granted := auth.NewGrantedClient("user-1", []auth.Grant{{Permission: "users.read"}})
+	_, err := signer.Mint(granted)
+	require.Equal(t, ErrPermissionsNotListed, err)
+
+	// tenant clients pass on the permissions for their home tenant
+	value, err := signer.Mint(auth.NewBasicTenantClient("user-1", "acme", map[string][]string{"acme": {"users.read"}, "globex": {"users.write"}}))
+	require.Nil(t, err)
+	a, err := verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, []string{"users.read"}, a.Permissions)
+
+	// assertions don't outlive the principal
+	expires := time.Now().Add(10 * time.Second)
+	value, err = signer.Mint(auth.NewScopedApiClient("user-1", "key-1", []string{"users.read"}, []string{"users.read"}, expires))
+	require.Nil(t, err)
+	a, err = verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, expires.Unix(), a.ExpiresAt)
+
+	// impersonated sessions pass on the actor, and only the allowed
+	// permissions of the subject
+	var session interface{}
+	impersonate := auth.NewImpersonator("ApiClient", auth.StandardErrorHandler, auth.ImpersonationConfig{
+		Permission:         "users.impersonate",
+		ActorContextKey:    "Actor",
+		AllowedPermissions: []string{"users.read"},
+		Resolve: func(actor interface{}, id string) (interface{}, error) {
+			return auth.NewBasicApiClient(id, []string{"users.read", "users.write"}), nil
+		},
+	})
+	h := impersonate(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		session = r.Context().Value("ApiClient")
+	}))
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Act-As", "user-2")
+	actor := auth.NewScopedApiClient("admin-1", "key-1", []string{"users.impersonate"}, []string{"users.impersonate"}, expires)
+	h.ServeHTTP(httptest.NewRecorder(), r.WithContext(context.WithValue(r.Context(), "ApiClient", actor)))
+	require.IsType(t, auth.Impersonation{}, session)
+
+	value, err = signer.Mint(session)
+	require.Nil(t, err)
+	a, err = verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, "user-2", a.Subject)
+	require.Equal(t, "admin-1", a.Actor)
+	require.Equal(t, []string{"users.read"}, a.Permissions)
+	require.Equal(t, expires.Unix(), a.ExpiresAt)
+
+	// and the actor is carried through further services
+	value, err = signer.Mint(Principal{*a})
+	require.Nil(t, err)
+	a, err = verifier.Verify(value)
+	require.Nil(t, err)
+	require.Equal(t, "admin-1", a.Actor)
+}
+
+func TestPropagation(t *testing.T) {
+	// downstream service authenticates via assertions only
+	verifier := NewVerifier("users", map[string][]byte{"gateway": []byte("gateway-key")})
+	authenticate := NewAssertionAuthenticator("ApiClient", auth.StandardErrorHandler, verifier)
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	downstream := httptest.NewServer(authenticate(authPerms(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		p := r.Context().Value("ApiClient").(Principal)
+		rw.Write([]byte("Hello " + p.AuthenticationID() + " from " + p.Issuer()))
+	}), "users.read")))
+	defer downstream.Close()
+
+	// the gateway forwards requests with the principal found in its context
+	client := &http.Client{Transport: &Transport{Signer: NewSigner("gateway", "users", []byte("gateway-key")), ContextKey: "ApiClient"}}
+	call := func(principal interface{}) (int, string) {
+		req, _ := http.NewRequest("GET", downstream.URL, nil)
+		if principal != nil {
+			req = req.WithContext(context.WithValue(req.Context(), "ApiClient", principal))
+		}
+		res, err := client.Do(req)
+		require.Nil(t, err)
+		out, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(out)
+	}
+
+	code, text := call(auth.NewBasicApiClient("client-1", []string{"users.read"}))
+	require.Equal(t, 200, code)
+	require.Equal(t, "Hello client-1 from gateway", text)
+	code, _ = call(auth.NewBasicApiClient("client-2", []string{"users.write"}))
+	require.Equal(t, 403, code)
+	code, _ = call(nil)
+	require.Equal(t, 401, code)
+
+	// forged assertions are rejected
+	req, _ := http.NewRequest("GET", downstream.URL, nil)
+	forged, _ := NewSigner("gateway", "users", []byte("wrong-key")).Mint(auth.NewBasicApiClient("client-1", []string{"users.read"}))
+	req.Header.Set(Header, forged)
+	res, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 401, res.StatusCode)
+}
@@ -2,7 +2,11 @@ package auth
 
 import (
 	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var (
@@ -20,6 +24,12 @@ type ErrPermissionDenied struct {
 	perm string
 }
 
+// NewErrPermissionDenied returns an ErrPermissionDenied for the permission, for
+// use by authorizers defined outside of this package.
+func NewErrPermissionDenied(perm string) ErrPermissionDenied {
+	return ErrPermissionDenied{perm}
+}
+
 func (e ErrPermissionDenied) Error() string {
 	return "permission denied: " + e.perm
 }
@@ -30,6 +40,49 @@ func (e ErrPermissionDenied) Permission() string {
 	return e.perm
 }
 
+// ErrTooManyRequests is returned when a client has made too many requests, and
+// must wait before trying again.
+type ErrTooManyRequests struct {
+	retryAfter time.Duration
+}
+
+// NewErrTooManyRequests returns an ErrTooManyRequests which asks the client to
+// wait for the specified duration before retrying.
+func NewErrTooManyRequests(retryAfter time.Duration) ErrTooManyRequests {
+	return ErrTooManyRequests{retryAfter}
+}
+
+func (e ErrTooManyRequests) Error() string {
+	return "too many requests, retry after " + e.retryAfter.String()
+}
+
+// RetryAfter returns how long the client should wait before retrying
+func (e ErrTooManyRequests) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// ErrStepUpRequired is returned when the principal is authenticated, but must
+// verify a second factor before the request is allowed, because it never has or
+// because it last did so too long ago.
+type ErrStepUpRequired struct {
+	maxAge time.Duration
+}
+
+// NewErrStepUpRequired returns an ErrStepUpRequired for a route requiring a second
+// factor verified within maxAge.
+func NewErrStepUpRequired(maxAge time.Duration) ErrStepUpRequired {
+	return ErrStepUpRequired{maxAge}
+}
+
+func (e ErrStepUpRequired) Error() string {
+	return "second factor required within " + e.maxAge.String()
+}
+
+// MaxAge returns how recently the second factor must have been verified
+func (e ErrStepUpRequired) MaxAge() time.Duration {
+	return e.maxAge
+}
+
 // Authenticator is a basic interface expected in the request context by
 // the client authorizer.  It must be identifiable in some way via
 // the `AuthenticatedId` method.
@@ -43,6 +96,19 @@ type Authorizer interface {
 	HasPermission(perm string) (bool, error)
 }
 
+// PermissionLister is implemented by objects which can list every permission they
+// grant, for example so that they can be passed on to other services.
+type PermissionLister interface {
+	Permissions() []string
+}
+
+// Expirer is implemented by objects whose credentials expire, such as api keys with
+// an expiry date.  Both the client authorizer and permissions authorizer treat
+// expired objects as unauthenticated.
+type Expirer interface {
+	ExpiresAt() time.Time
+}
+
 // ErrorHandler is called when an error occurs in authenticator or authorizer
 // middlewares
 type ErrorHandler func(http.ResponseWriter, *http.Request, error)
@@ -74,6 +140,27 @@ func StandardErrorHandler(w http.ResponseWriter, r *http.Request, e error) {
 		return
 	}
 
+	if _, ok := e.(ErrGrantExpired); ok {
+		w.WriteHeader(403)
+		w.Write([]byte("Access expired"))
+		return
+	}
+
+	if stepUp, ok := e.(ErrStepUpRequired); ok {
+		// challenge as described in RFC 9470, OAuth 2.0 Step Up Authentication
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="A second factor is required", max_age=`+strconv.Itoa(int(stepUp.MaxAge().Seconds())))
+		w.WriteHeader(401)
+		w.Write([]byte("Second factor required"))
+		return
+	}
+
+	if tooMany, ok := e.(ErrTooManyRequests); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter().Seconds()))))
+		w.WriteHeader(429)
+		w.Write([]byte("Too many requests"))
+		return
+	}
+
 	// NOTE: perhaps it makes sense to handle redirects via custom error type here, but
 	// I can't decide if that feels gross or not.
 
@@ -85,16 +172,19 @@ func StandardErrorHandler(w http.ResponseWriter, r *http.Request, e error) {
 // NewClientAuthorizer returns an authorization middleware that requires a Client
 // be set in the request context at the specified key. The client instance must have an
 // identifier of some sort set, meaning it cannot be an empty string.
+//
+// The returned handlers implement ProtectedHandler, so protected routes can be
+// inspected.
 func NewClientAuthorizer(keyname string, failFn ErrorHandler) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
-		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		return Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 			_, err := checkClient(keyname, r)
 			if err != nil {
 				failFn(rw, r, err)
 				return
 			}
 			handler.ServeHTTP(rw, r)
-		})
+		}), handler, Requirement{Authenticated: true})
 	}
 }
 
@@ -113,31 +203,47 @@ func NewClientAuthorizerMiddleware(keyname string, failFn ErrorHandler) func(htt
 }
 
 func checkClient(keyname string, req *http.Request) (bool, error) {
-	c := req.Context().Value(keyname)
+	err := CheckClient(req.Context().Value(keyname))
+	notifyAuthorization(req, keyname, err)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckClient performs the same checks as the client authorizer on an object, for
+// use outside of http handlers.  It returns ErrAuthenticationRequired if the
+// object isn't an unexpired Authenticator, and ErrAuthorizationFailed if it has
+// no identifier.
+func CheckClient(c interface{}) error {
 	client, ok := c.(Authenticator)
-	if !ok {
-		return false, ErrAuthenticationRequired
+	if !ok || isExpired(c) {
+		return ErrAuthenticationRequired
 	}
 	if "" == client.AuthenticationID() {
-		return false, ErrAuthorizationFailed
+		return ErrAuthorizationFailed
 	}
-	return true, nil
+	return nil
 }
 
 // NewPermissionsAuthorizer return an authorization middleware that requires an Authorizer
 // be set in the request context at the specified key.  The middleware facilitates wrapping
 // `http.HandlerFunc`s with permission checks, which will only execute if the Authorizer
-// grants all specified permissions.
+// grants all specified permissions.  Objects implementing RequestAuthorizer are
+// checked against the request being made.
+//
+// The returned handlers implement ProtectedHandler, so the permissions required by
+// each route can be inspected.
 func NewPermissionsAuthorizer(keyname string, failFn ErrorHandler) func(http.Handler, ...string) http.Handler {
 	return func(handler http.Handler, perms ...string) http.Handler {
-		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		return Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 			_, err := checkPermissions(keyname, r, perms...)
 			if err != nil {
 				failFn(rw, r, err)
 				return
 			}
 			handler.ServeHTTP(rw, r)
-		})
+		}), handler, Requirement{Authenticated: true, Permissions: perms})
 	}
 }
 
@@ -157,24 +263,76 @@ func NewPermissionsAuthorizerMiddleware(keyname string, failFn ErrorHandler) fun
 }
 
 func checkPermissions(keyname string, req *http.Request, perms ...string) (bool, error) {
-	a := req.Context().Value(keyname)
+	err := checkRequestPermissions(req.Context().Value(keyname), req, perms...)
+	notifyAuthorization(req, keyname, err)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckPermissions performs the same checks as the permissions authorizer on an
+// object, for use outside of http handlers.  It returns ErrAuthenticationRequired
+// if the object isn't an unexpired Authorizer, and ErrPermissionDenied for the
+// first permission which isn't granted.
+func CheckPermissions(a interface{}, perms ...string) error {
 	// must actually have an authorizer to check - if not, the request must not
 	// have been authenticated
 	authorizer, ok := a.(Authorizer)
-	if !ok {
-		return false, ErrAuthenticationRequired
+	if !ok || isExpired(a) {
+		return ErrAuthenticationRequired
 	}
 
 	// check each permission - end early if any one is denied
 	for _, perm := range perms {
 		if allowed, err := authorizer.HasPermission(perm); err != nil {
-			return false, err
+			return err
 		} else if !allowed {
-			return false, ErrPermissionDenied{perm}
+			return ErrPermissionDenied{perm}
 		}
 	}
 
-	return true, nil
+	return nil
+}
+
+// checkRequestPermissions checks permissions with the RequestAuthorizer interface
+// if the object implements it, or otherwise as with CheckPermissions
+func checkRequestPermissions(a interface{}, req *http.Request, perms ...string) error {
+	authorizer, ok := a.(RequestAuthorizer)
+	if !ok {
+		return CheckPermissions(a, perms...)
+	}
+	if _, ok := a.(Authorizer); !ok || isExpired(a) {
+		return ErrAuthenticationRequired
+	}
+	for _, perm := range perms {
+		if err := authorizer.AuthorizeRequest(req, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isExpired returns whether or not the object implements Expirer and has expired.
+// A zero expiry time means the object never expires.
+func isExpired(obj interface{}) bool {
+	e, ok := obj.(Expirer)
+	if !ok {
+		return false
+	}
+	expires := e.ExpiresAt()
+	return !expires.IsZero() && !time.Now().Before(expires)
+}
+
+// RemoteIP returns the IP address of the client that sent the request, taken from
+// `http.Request.RemoteAddr`.  Headers set by proxies are not consulted, as they
+// can be forged by the client.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // NewBasicApiClient return a new BasicApiClient with the specified
@@ -194,6 +352,10 @@ func (b BasicApiClient) AuthenticationID() string {
 	return b.id
 }
 
+func (b BasicApiClient) Permissions() []string {
+	return b.perms
+}
+
 func (b BasicApiClient) HasPermission(perm string) (bool, error) {
 	for _, p := range b.perms {
 		if p == perm {
@@ -202,3 +364,67 @@ func (b BasicApiClient) HasPermission(perm string) (bool, error) {
 	}
 	return false, nil
 }
+
+// NewScopedApiClient returns a new ScopedApiClient for an api key belonging to the
+// owner.  The key is limited to the scopes, out of the owner's permissions, and
+// expires at the specified time.  A zero expiry time means the key never expires.
+func NewScopedApiClient(ownerID, keyID string, ownerPerms, scopes []string, expires time.Time) ScopedApiClient {
+	return ScopedApiClient{ownerID, keyID, ownerPerms, scopes, expires}
+}
+
+// ScopedApiClient describes an api key which is limited to a subset of its
+// owner's permissions, and may expire.  It implements the Authenticator,
+// Authorizer and Expirer interfaces.
+type ScopedApiClient struct {
+	ownerID    string
+	keyID      string
+	ownerPerms []string
+	scopes     []string
+	expires    time.Time
+}
+
+// AuthenticationID returns the id of the key's owner
+func (s ScopedApiClient) AuthenticationID() string {
+	return s.ownerID
+}
+
+// KeyID returns the id of the api key itself
+func (s ScopedApiClient) KeyID() string {
+	return s.keyID
+}
+
+// Scopes returns the permissions the key was limited to
+func (s ScopedApiClient) Scopes() []string {
+	return s.scopes
+}
+
+// ExpiresAt returns when the key expires
+func (s ScopedApiClient) ExpiresAt() time.Time {
+	return s.expires
+}
+
+// Permissions returns the owner's permissions which are within the key's scopes
+func (s ScopedApiClient) Permissions() []string {
+	var perms []string
+	for _, p := range s.ownerPerms {
+		if contains(s.scopes, p) {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// HasPermission only grants permissions which are both granted to the owner, and
+// included in the key's scopes.  A key with no scopes grants no permissions.
+func (s ScopedApiClient) HasPermission(perm string) (bool, error) {
+	return contains(s.ownerPerms, perm) && contains(s.scopes, perm), nil
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
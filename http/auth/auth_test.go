@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -93,3 +94,52 @@ func TestNewPermissionsAuthorizer(t *testing.T) {
 	require.Equal(t, 200, res.StatusCode)
 	require.Equal(t, "Hello world!", string(out))
 }
+
+func TestScopedApiClient(t *testing.T) {
+	authClient := NewClientAuthorizer("ApiClient", StandardErrorHandler)(http.HandlerFunc(handler))
+	authPerms := NewPermissionsAuthorizer("ApiClient", StandardErrorHandler)
+	call := func(h http.Handler, client ScopedApiClient) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		req := r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Result().StatusCode
+	}
+
+	ownerPerms := []string{"users.read", "users.write"}
+	key := NewScopedApiClient("owner-1", "key-1", ownerPerms, []string{"users.read", "users.delete"}, time.Now().Add(time.Hour))
+	require.Equal(t, "owner-1", key.AuthenticationID())
+	require.Equal(t, "key-1", key.KeyID())
+
+	// permissions must be granted to the owner, and in the key's scopes
+	require.Equal(t, 200, call(authClient, key))
+	require.Equal(t, 200, call(authPerms(http.HandlerFunc(handler), "users.read"), key))
+	require.Equal(t, 403, call(authPerms(http.HandlerFunc(handler), "users.write"), key))
+	require.Equal(t, 403, call(authPerms(http.HandlerFunc(handler), "users.delete"), key))
+
+	// keys without an expiry never expire
+	key = NewScopedApiClient("owner-1", "key-2", ownerPerms, []string{"users.read"}, time.Time{})
+	require.Equal(t, 200, call(authClient, key))
+
+	// expired keys are rejected
+	key = NewScopedApiClient("owner-1", "key-3", ownerPerms, []string{"users.read"}, time.Now().Add(-time.Second))
+	require.Equal(t, 401, call(authClient, key))
+	require.Equal(t, 401, call(authPerms(http.HandlerFunc(handler), "users.read"), key))
+}
+
+func TestProtectedHandlers(t *testing.T) {
+	authClient := NewClientAuthorizer("ApiClient", StandardErrorHandler)
+	authPerms := NewPermissionsAuthorizer("ApiClient", StandardErrorHandler)
+
+	h, ok := authClient(http.HandlerFunc(handler)).(ProtectedHandler)
+	require.True(t, ok)
+	require.Equal(t, Requirement{Authenticated: true}, h.Requirement())
+
+	h, ok = authPerms(http.HandlerFunc(handler), "foo", "bar").(ProtectedHandler)
+	require.True(t, ok)
+	require.Equal(t, Requirement{Authenticated: true, Permissions: []string{"foo", "bar"}}, h.Requirement())
+
+	// nested authorizers combine their requirements
+	h = authClient(authPerms(authPerms(http.HandlerFunc(handler), "foo"), "foo", "bar")).(ProtectedHandler)
+	require.Equal(t, Requirement{Authenticated: true, Permissions: []string{"foo", "bar"}}, h.Requirement())
+}
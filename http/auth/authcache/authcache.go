@@ -0,0 +1,187 @@
+// Package authcache provides a caching decorator for authenticator callbacks, such
+// as `apikeyauth.APIKeyAuthenticator`, so that apps don't need to hit their
+// database to validate the same credential on every request.
+package authcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+var errPanicked = errors.New("authcache: authenticator panicked")
+
+// Options configure how results are cached
+type Options struct {
+	// TTL is how long successful results are cached
+	TTL time.Duration
+	// NegativeTTL is how long `auth.ErrAuthenticationRequired` results are
+	// cached.  It should generally be shorter than TTL, and zero disables
+	// caching of failures.  Other errors are never cached.
+	NegativeTTL time.Duration
+	// MaxEntries bounds the size of the cache, evicting the least recently used
+	// entries once reached.  Zero means no limit.
+	MaxEntries int
+}
+
+// Cache wraps an authenticator callback, caching its results.  Concurrent lookups
+// of the same credential result in a single call to the wrapped authenticator.
+// It is safe for concurrent use.
+type Cache struct {
+	authFn  func(string) (interface{}, error)
+	opts    Options
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List
+	calls   map[[sha256.Size]byte]*call
+}
+
+type entry struct {
+	key     [sha256.Size]byte
+	obj     interface{}
+	err     error
+	expires time.Time
+}
+
+// call is an in-flight lookup, which concurrent lookups of the same credential
+// wait on
+type call struct {
+	wg        sync.WaitGroup
+	obj       interface{}
+	err       error
+	forgotten bool
+}
+
+// New returns a Cache wrapping the authenticator callback, which validates a
+// credential and returns an object describing the authenticated client.
+func New(authFn func(credential string) (interface{}, error), opts Options) *Cache {
+	return &Cache{
+		authFn:  authFn,
+		opts:    opts,
+		now:     time.Now,
+		entries: map[[sha256.Size]byte]*list.Element{},
+		lru:     list.New(),
+		calls:   map[[sha256.Size]byte]*call{},
+	}
+}
+
+// Authenticate returns the cached result for the credential if available, and
+// otherwise calls the wrapped authenticator.  It has the same signature as an
+// authenticator callback, so can be passed in place of one:
+//
+//	cache := authcache.New(appAuthenticateAPIKey, authcache.Options{TTL: time.Minute})
+//	authenticate := apikeyauth.NewAPIKeyAuthenticator("Key", "ApiClient", auth.StandardErrorHandler, cache.Authenticate)
+func (c *Cache) Authenticate(credential string) (interface{}, error) {
+	key := sha256.Sum256([]byte(credential))
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return e.obj, e.err
+		}
+		c.remove(el)
+	}
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		cl.wg.Wait()
+		return cl.obj, cl.err
+	}
+	cl := &call{}
+	cl.wg.Add(1)
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	c.do(key, cl, credential)
+	return cl.obj, cl.err
+}
+
+// do calls the wrapped authenticator for an in-flight lookup.  The lookup is
+// always finished, even if the authenticator panics, so that waiting and later
+// lookups of the credential aren't blocked forever.
+func (c *Cache) do(key [sha256.Size]byte, cl *call, credential string) {
+	completed := false
+	defer cl.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !cl.forgotten {
+			delete(c.calls, key)
+			if completed {
+				c.store(key, cl.obj, cl.err)
+			}
+		}
+	}()
+
+	// waiters get an error, rather than a nil result, if the authenticator panics
+	cl.err = errPanicked
+	cl.obj, cl.err = c.authFn(credential)
+	completed = true
+}
+
+// Invalidate removes any cached result for the credential, so that revocations
+// take effect immediately.  A lookup already in progress for the credential will
+// not be cached.
+func (c *Cache) Invalidate(credential string) {
+	key := sha256.Sum256([]byte(credential))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if cl, ok := c.calls[key]; ok {
+		cl.forgotten = true
+		delete(c.calls, key)
+	}
+}
+
+// Purge removes all cached results
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[[sha256.Size]byte]*list.Element{}
+	c.lru.Init()
+	for key, cl := range c.calls {
+		cl.forgotten = true
+		delete(c.calls, key)
+	}
+}
+
+// Len returns the number of cached results
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) store(key [sha256.Size]byte, obj interface{}, err error) {
+	var ttl time.Duration
+	switch {
+	case err == nil:
+		ttl = c.opts.TTL
+	case err == auth.ErrAuthenticationRequired:
+		ttl = c.opts.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&entry{key, obj, err, c.now().Add(ttl)})
+	if c.opts.MaxEntries > 0 {
+		for c.lru.Len() > c.opts.MaxEntries {
+			c.remove(c.lru.Back())
+		}
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
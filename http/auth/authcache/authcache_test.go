@@ -0,0 +1,154 @@
+package authcache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/stretchr/testify/require"
+)
+
+// countingAuthenticator returns an authenticator that accepts a fixed set of keys,
+// and counts how many times each key was looked up
+func countingAuthenticator(keys ...string) (func(string) (interface{}, error), map[string]int) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	return func(key string) (interface{}, error) {
+		mu.Lock()
+		calls[key]++
+		mu.Unlock()
+		if key == "broken" {
+			return nil, errors.New("database unavailable")
+		}
+		for _, k := range keys {
+			if k == key {
+				return auth.NewBasicApiClient(k, nil), nil
+			}
+		}
+		return nil, auth.ErrAuthenticationRequired
+	}, calls
+}
+
+func newTestCache(authFn func(string) (interface{}, error), opts Options) (*Cache, func(time.Duration)) {
+	now := time.Now()
+	c := New(authFn, opts)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCacheTTL(t *testing.T) {
+	authFn, calls := countingAuthenticator("good-key")
+	c, advance := newTestCache(authFn, Options{TTL: time.Minute, NegativeTTL: time.Second})
+
+	// successful results cached for the TTL
+	for i := 0; i < 3; i++ {
+		obj, err := c.Authenticate("good-key")
+		require.Nil(t, err)
+		require.Equal(t, "good-key", obj.(auth.BasicApiClient).AuthenticationID())
+	}
+	require.Equal(t, 1, calls["good-key"])
+	advance(time.Minute)
+	c.Authenticate("good-key")
+	require.Equal(t, 2, calls["good-key"])
+
+	// failures cached for the negative TTL
+	for i := 0; i < 3; i++ {
+		_, err := c.Authenticate("bad-key")
+		require.Equal(t, auth.ErrAuthenticationRequired, err)
+	}
+	require.Equal(t, 1, calls["bad-key"])
+	advance(time.Second)
+	c.Authenticate("bad-key")
+	require.Equal(t, 2, calls["bad-key"])
+
+	// other errors not cached
+	c.Authenticate("broken")
+	_, err := c.Authenticate("broken")
+	require.EqualError(t, err, "database unavailable")
+	require.Equal(t, 2, calls["broken"])
+}
+
+func TestCacheEviction(t *testing.T) {
+	authFn, calls := countingAuthenticator("key-1", "key-2", "key-3")
+	c, _ := newTestCache(authFn, Options{TTL: time.Minute, MaxEntries: 2})
+
+	c.Authenticate("key-1")
+	c.Authenticate("key-2")
+	c.Authenticate("key-1")
+	c.Authenticate("key-3")
+	require.Equal(t, 2, c.Len())
+
+	// key-2 was least recently used, so was evicted
+	c.Authenticate("key-1")
+	c.Authenticate("key-3")
+	require.Equal(t, 1, calls["key-1"])
+	require.Equal(t, 1, calls["key-3"])
+	c.Authenticate("key-2")
+	require.Equal(t, 2, calls["key-2"])
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	authFn, calls := countingAuthenticator("key-1", "key-2")
+	c, _ := newTestCache(authFn, Options{TTL: time.Minute})
+
+	c.Authenticate("key-1")
+	c.Authenticate("key-2")
+	c.Invalidate("key-1")
+	c.Authenticate("key-1")
+	c.Authenticate("key-2")
+	require.Equal(t, 2, calls["key-1"])
+	require.Equal(t, 1, calls["key-2"])
+
+	c.Purge()
+	require.Equal(t, 0, c.Len())
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	c := New(func(key string) (interface{}, error) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		return auth.NewBasicApiClient(key, nil), nil
+	}, Options{TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obj, err := c.Authenticate("good-key")
+			require.Nil(t, err)
+			require.Equal(t, "good-key", obj.(auth.BasicApiClient).AuthenticationID())
+		}()
+	}
+
+	// give the lookups a chance to pile up before releasing the first
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, 1, calls)
+}
+
+func TestCachePanic(t *testing.T) {
+	panics := true
+	c := New(func(key string) (interface{}, error) {
+		if panics {
+			panic("database driver bug")
+		}
+		return auth.NewBasicApiClient(key, nil), nil
+	}, Options{TTL: time.Minute})
+
+	require.Panics(t, func() { c.Authenticate("good-key") })
+
+	// the failed lookup isn't left in flight, so later lookups don't block
+	panics = false
+	obj, err := c.Authenticate("good-key")
+	require.Nil(t, err)
+	require.Equal(t, "good-key", obj.(auth.BasicApiClient).AuthenticationID())
+}
@@ -0,0 +1,280 @@
+// Package authmetrics counts authentications and authorization outcomes, and
+// times authenticator callbacks, exposing them in the Prometheus text exposition
+// format.  It's implemented with the standard library only, so apps don't need the
+// Prometheus client to scrape auth metrics.
+package authmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the authenticator latency
+// histogram buckets, the same as the Prometheus client's defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMechanisms are the Authorization schemes counted under their own
+// mechanism label by default
+var DefaultMechanisms = []string{"apikey", "basic", "bearer", "key"}
+
+const (
+	authenticationsName = "http_auth_authentications_total"
+	authorizationsName  = "http_auth_authorizations_total"
+	durationName        = "http_auth_authenticator_duration_seconds"
+)
+
+// Metrics collects auth metrics.  Counts are collected by the Observer, which must
+// be set on requests with `auth.NewObserverInjector`, and authenticator latencies
+// by wrapping callbacks with InstrumentAuthenticator.  The zero value is ready to
+// use.
+type Metrics struct {
+	// Buckets of the latency histograms, defaults to DefaultBuckets
+	Buckets []float64
+	// Mechanisms are the lowercased Authorization schemes counted under their
+	// own mechanism label, defaults to DefaultMechanisms.  Other schemes are
+	// counted as `other`, so that clients can't create a series for every
+	// header they send.
+	Mechanisms []string
+	// Route returns the route label for a request.  It should return the
+	// route's template rather than the request path, such as
+	// `authmux.RouteTemplate`, to avoid labels for every id in a path.
+	// Defaults to no route label values.
+	Route func(r *http.Request) string
+
+	mu              sync.Mutex
+	authentications map[authenticationLabels]uint64
+	authorizations  map[authorizationLabels]uint64
+	durations       map[string]*histogram
+}
+
+type authenticationLabels struct {
+	mechanism, result, reason string
+}
+
+type authorizationLabels struct {
+	mechanism, route, result, reason string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// New returns empty Metrics
+func New() *Metrics {
+	return &Metrics{Buckets: DefaultBuckets, Mechanisms: DefaultMechanisms}
+}
+
+// init creates the maps of a zero value Metrics.  It must be called with the lock
+// held.
+func (m *Metrics) init() {
+	if m.authentications == nil {
+		m.authentications = map[authenticationLabels]uint64{}
+		m.authorizations = map[authorizationLabels]uint64{}
+		m.durations = map[string]*histogram{}
+	}
+}
+
+func (m *Metrics) buckets() []float64 {
+	if m.Buckets == nil {
+		return DefaultBuckets
+	}
+	return m.Buckets
+}
+
+// Observer returns an auth.Observer which counts outcomes by mechanism, route and
+// reason.  The mechanism is the lowercased scheme of the request's Authorization
+// header, e.g. `apikey` or `bearer`, `other` if it isn't one of Mechanisms, or
+// `none` if it has none.
+func (m *Metrics) Observer() auth.Observer {
+	return auth.ObserverFuncs{
+		Authenticated: func(r *http.Request, principal interface{}) {
+			m.countAuthentication(authenticationLabels{m.Mechanism(r), "success", "none"})
+		},
+		AuthenticationFailed: func(r *http.Request, err error) {
+			m.countAuthentication(authenticationLabels{m.Mechanism(r), "failure", Reason(err)})
+		},
+		Authorized: func(r *http.Request, principal interface{}) {
+			m.countAuthorization(authorizationLabels{m.Mechanism(r), m.route(r), "allowed", "none"})
+		},
+		Denied: func(r *http.Request, principal interface{}, err error) {
+			m.countAuthorization(authorizationLabels{m.Mechanism(r), m.route(r), "denied", Reason(err)})
+		},
+	}
+}
+
+// InstrumentAuthenticator wraps an authenticator callback, such as an
+// `apikeyauth.APIKeyAuthenticator`, recording how long each call takes in the
+// latency histogram for the mechanism.
+func (m *Metrics) InstrumentAuthenticator(mechanism string, authFn func(credential string) (interface{}, error)) func(credential string) (interface{}, error) {
+	return func(credential string) (interface{}, error) {
+		start := time.Now()
+		obj, err := authFn(credential)
+		m.observeDuration(mechanism, time.Since(start))
+		return obj, err
+	}
+}
+
+func (m *Metrics) route(r *http.Request) string {
+	if m.Route == nil {
+		return ""
+	}
+	return m.Route(r)
+}
+
+func (m *Metrics) countAuthentication(labels authenticationLabels) {
+	m.mu.Lock()
+	m.init()
+	m.authentications[labels]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) countAuthorization(labels authorizationLabels) {
+	m.mu.Lock()
+	m.init()
+	m.authorizations[labels]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) observeDuration(mechanism string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+	h, ok := m.durations[mechanism]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets()))}
+		m.durations[mechanism] = h
+	}
+	seconds := d.Seconds()
+	for i, upper := range m.buckets() {
+		if seconds <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// Mechanism returns the mechanism label for a request, the lowercased scheme of
+// its Authorization header if it's one of Mechanisms, `other` if not, or `none`
+func (m *Metrics) Mechanism(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "none"
+	}
+	scheme := strings.ToLower(strings.SplitN(header, " ", 2)[0])
+	mechanisms := m.Mechanisms
+	if mechanisms == nil {
+		mechanisms = DefaultMechanisms
+	}
+	for _, mechanism := range mechanisms {
+		if scheme == mechanism {
+			return mechanism
+		}
+	}
+	return "other"
+}
+
+// Reason returns the reason label for an error from an authenticator or
+// authorizer
+func Reason(err error) string {
+	switch err.(type) {
+	case auth.ErrPermissionDenied:
+		return "permission_denied"
+	case auth.ErrGrantExpired:
+		return "grant_expired"
+	case auth.ErrTooManyRequests:
+		return "too_many_requests"
+	case auth.ErrStepUpRequired:
+		return "step_up_required"
+	}
+	switch err {
+	case auth.ErrAuthenticationRequired:
+		return "authentication_required"
+	case auth.ErrAuthorizationFailed:
+		return "authorization_failed"
+	}
+	return "error"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(rw)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.  Series are
+// sorted, so output is stable.
+func (m *Metrics) Write(out io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+
+	header(w, authenticationsName, "counter", "Authentication attempts by mechanism, result and reason.")
+	var lines []string
+	for l, count := range m.authentications {
+		lines = append(lines, series(authenticationsName, []string{"mechanism", l.mechanism, "reason", l.reason, "result", l.result}, strconv.FormatUint(count, 10)))
+	}
+	writeSorted(w, lines)
+
+	header(w, authorizationsName, "counter", "Authorization checks by mechanism, route, result and reason.")
+	lines = lines[:0]
+	for l, count := range m.authorizations {
+		lines = append(lines, series(authorizationsName, []string{"mechanism", l.mechanism, "reason", l.reason, "result", l.result, "route", l.route}, strconv.FormatUint(count, 10)))
+	}
+	writeSorted(w, lines)
+
+	header(w, durationName, "histogram", "Latency of authenticator callbacks by mechanism.")
+	mechanisms := make([]string, 0, len(m.durations))
+	for mechanism := range m.durations {
+		mechanisms = append(mechanisms, mechanism)
+	}
+	sort.Strings(mechanisms)
+	for _, mechanism := range mechanisms {
+		h := m.durations[mechanism]
+		for i, upper := range m.buckets() {
+			w.WriteString(series(durationName+"_bucket", []string{"le", formatFloat(upper), "mechanism", mechanism}, strconv.FormatUint(h.counts[i], 10)))
+		}
+		w.WriteString(series(durationName+"_bucket", []string{"le", "+Inf", "mechanism", mechanism}, strconv.FormatUint(h.count, 10)))
+		w.WriteString(series(durationName+"_sum", []string{"mechanism", mechanism}, formatFloat(h.sum)))
+		w.WriteString(series(durationName+"_count", []string{"mechanism", mechanism}, strconv.FormatUint(h.count, 10)))
+	}
+	return w.Flush()
+}
+
+func header(w *bufio.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSorted(w *bufio.Writer, lines []string) {
+	sort.Strings(lines)
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+}
+
+// series formats a sample line, with labels given as name, value pairs
+func series(name string, labels []string, value string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return name + "{" + strings.Join(pairs, ",") + "} " + value + "\n"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
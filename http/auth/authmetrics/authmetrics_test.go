@@ -0,0 +1,132 @@
+package authmetrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/authmux"
+)
+
+func authenticateApiKey(key string) (interface{}, error) {
+	switch key {
+	case "reader":
+		return auth.NewBasicApiClient("reader", []string{"users.read"}), nil
+	case "writer":
+		return auth.NewBasicApiClient("writer", []string{"users.read", "users.write"}), nil
+	}
+	return nil, auth.ErrAuthenticationRequired
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := New()
+	metrics.Route = authmux.RouteTemplate
+
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	router := mux.NewRouter()
+	router.Handle("/users/{id}", authPerms(h, "users.read")).Methods("GET")
+	router.Handle("/users/{id}", authPerms(h, "users.write")).Methods("DELETE")
+	router.Handle("/metrics", metrics)
+
+	router.Use(
+		auth.NewObserverInjector(metrics.Observer()),
+		apikeyauth.NewAPIKeyAuthenticator("ApiKey", "ApiClient", auth.StandardErrorHandler, metrics.InstrumentAuthenticator("apikey", authenticateApiKey)),
+	)
+
+	call := func(method, path, key string) {
+		r := httptest.NewRequest(method, "http://example.com"+path, nil)
+		if key != "" {
+			r.Header.Set("Authorization", "ApiKey "+key)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	call("GET", "/users/1", "reader")
+	call("GET", "/users/2", "reader")
+	call("DELETE", "/users/1", "reader")
+	call("DELETE", "/users/1", "writer")
+	call("DELETE", "/users/1", "invalid")
+	call("GET", "/users/1", "")
+
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	res := rw.Result()
+	require.Equal(t, 200, res.StatusCode)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+	out := rw.Body.String()
+
+	for _, line := range []string{
+		`# TYPE http_auth_authentications_total counter`,
+		`http_auth_authentications_total{mechanism="apikey",reason="authentication_required",result="failure"} 1`,
+		`http_auth_authentications_total{mechanism="apikey",reason="none",result="success"} 4`,
+		`# TYPE http_auth_authorizations_total counter`,
+		`http_auth_authorizations_total{mechanism="apikey",reason="none",result="allowed",route="/users/{id}"} 3`,
+		`http_auth_authorizations_total{mechanism="apikey",reason="permission_denied",result="denied",route="/users/{id}"} 1`,
+		`http_auth_authorizations_total{mechanism="none",reason="authentication_required",result="denied",route="/users/{id}"} 1`,
+		`# TYPE http_auth_authenticator_duration_seconds histogram`,
+		`http_auth_authenticator_duration_seconds_bucket{le="10",mechanism="apikey"} 5`,
+		`http_auth_authenticator_duration_seconds_bucket{le="+Inf",mechanism="apikey"} 5`,
+		`http_auth_authenticator_duration_seconds_count{mechanism="apikey"} 5`,
+	} {
+		require.Contains(t, strings.Split(out, "\n"), line)
+	}
+
+	// output is stable
+	var buf bytes.Buffer
+	require.Nil(t, metrics.Write(&buf))
+	require.Equal(t, out, buf.String())
+}
+
+func TestZeroMetrics(t *testing.T) {
+	// the zero value collects with the default buckets and mechanisms
+	var metrics Metrics
+	observer := metrics.Observer()
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	observer.OnAuthenticated(r, nil)
+	observer.OnDenied(r, nil, auth.NewErrPermissionDenied("users.read"))
+	metrics.InstrumentAuthenticator("bearer", authenticateApiKey)("reader")
+
+	var buf bytes.Buffer
+	require.Nil(t, metrics.Write(&buf))
+	lines := strings.Split(buf.String(), "\n")
+	require.Contains(t, lines, `http_auth_authentications_total{mechanism="bearer",reason="none",result="success"} 1`)
+	require.Contains(t, lines, `http_auth_authorizations_total{mechanism="bearer",reason="permission_denied",result="denied",route=""} 1`)
+	require.Contains(t, lines, `http_auth_authenticator_duration_seconds_bucket{le="10",mechanism="bearer"} 1`)
+}
+
+func TestMechanism(t *testing.T) {
+	metrics := New()
+	for header, expected := range map[string]string{
+		"":                   "none",
+		"Bearer token":       "bearer",
+		"ApiKey key":         "apikey",
+		"Random-1 value":     "other",
+		"Random-2":           "other",
+		"Key some-key-value": "key",
+	} {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		require.Equal(t, expected, metrics.Mechanism(r), header)
+	}
+
+	metrics.Mechanisms = []string{"custom"}
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Custom value")
+	require.Equal(t, "custom", metrics.Mechanism(r))
+	r.Header.Set("Authorization", "Bearer token")
+	require.Equal(t, "other", metrics.Mechanism(r))
+}
+
+func TestLabelEscaping(t *testing.T) {
+	require.Equal(t, "m{route=\"/a\\\"b\\\\c\\nd\"} 1\n", series("m", []string{"route", "/a\"b\\c\nd"}, "1"))
+}
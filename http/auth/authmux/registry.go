@@ -0,0 +1,138 @@
+// Package authmux inspects the auth requirements of routes registered with a
+// gorilla/mux router.  It's kept separate from the auth package so that apps not
+// using gorilla/mux don't need to import it.
+package authmux
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+)
+
+// Route describes the auth requirements of a single route and method
+type Route struct {
+	// Method is the HTTP method, or `*` for routes matching any method
+	Method string `json:"method"`
+	// Path is the path template the route was registered with
+	Path string `json:"path"`
+	// Protected is true if the route handler was wrapped by an auth authorizer
+	Protected bool `json:"protected"`
+	// Authenticated is true if an authenticated principal is required
+	Authenticated bool `json:"authenticated"`
+	// Permissions which must all be granted
+	Permissions []string `json:"permissions"`
+	// TenantScoped is true if permissions are checked in the tenant the request
+	// is for
+	TenantScoped bool `json:"tenantScoped,omitempty"`
+}
+
+// Registry lists the auth requirements of the routes in a router
+type Registry struct {
+	routes []Route
+}
+
+// NewRegistry walks the router, recording the requirements of every route.  Routes
+// protected by the authorizers in the auth package are detected via
+// `auth.ProtectedHandler`.  The router should be fully configured first, as
+// routes added later are not included.
+func NewRegistry(router *mux.Router) (*Registry, error) {
+	var routes []Route
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		handler := route.GetHandler()
+		if handler == nil {
+			// subrouters are walked separately
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// routes without a path, such as those only matching a host,
+			// match every path
+			path = "*"
+		}
+		methods, err := route.GetMethods()
+		if err != nil || len(methods) == 0 {
+			methods = []string{"*"}
+		}
+
+		var req auth.Requirement
+		protected, isProtected := handler.(auth.ProtectedHandler)
+		if isProtected {
+			req = protected.Requirement()
+		}
+		for _, method := range methods {
+			perms := req.Permissions
+			if perms == nil {
+				perms = []string{}
+			}
+			routes = append(routes, Route{
+				Method:        method,
+				Path:          path,
+				Protected:     isProtected,
+				Authenticated: req.Authenticated,
+				Permissions:   perms,
+				TenantScoped:  req.TenantScoped,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return &Registry{routes}, nil
+}
+
+// Routes returns the requirements of every route, sorted by path and method
+func (r *Registry) Routes() []Route {
+	out := make([]Route, len(r.routes))
+	copy(out, r.routes)
+	return out
+}
+
+// Permissions returns every permission required by any route, sorted
+func (r *Registry) Permissions() []string {
+	seen := map[string]bool{}
+	perms := []string{}
+	for _, route := range r.routes {
+		for _, p := range route.Permissions {
+			if !seen[p] {
+				seen[p] = true
+				perms = append(perms, p)
+			}
+		}
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// ServeHTTP responds with the requirements of every route as JSON, so that they
+// can be used for generating docs, or compared between releases.  It should
+// generally be protected itself.
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	jsonio.Respond(rw, 200, map[string]interface{}{"routes": r.routes})
+}
+
+// RouteTemplate returns the path template of the route matching the request, or an
+// empty string if it wasn't routed by a mux router.  It's useful for labelling
+// requests without a label for every id in a path, e.g. as `authmetrics.Route`.
+func RouteTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,67 @@
+package authmux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+)
+
+func appHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Write([]byte("Hello world!"))
+}
+
+func appRouter() *mux.Router {
+	authClient := auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	authTenant := auth.NewTenantPermissionsAuthorizer("ApiClient", auth.TenantFromPath("/tenants/"), auth.StandardErrorHandler)
+	h := http.HandlerFunc(appHandler)
+
+	router := mux.NewRouter()
+	router.Handle("/public", h).Methods("GET")
+	router.Handle("/private", authClient(h)).Methods("GET", "HEAD")
+	router.Handle("/private/users", authPerms(h, "users.read")).Methods("GET")
+	router.Handle("/private/users", authPerms(h, "users.read", "users.write")).Methods("POST")
+	router.Handle("/anything", authClient(h))
+
+	tenants := router.PathPrefix("/tenants/{tenant}").Subrouter()
+	tenants.Handle("/users", authTenant(h, "users.read")).Methods("GET")
+	return router
+}
+
+func TestRegistry(t *testing.T) {
+	registry, err := NewRegistry(appRouter())
+	require.Nil(t, err)
+
+	require.Equal(t, []Route{
+		{Method: "*", Path: "/anything", Protected: true, Authenticated: true, Permissions: []string{}},
+		{Method: "GET", Path: "/private", Protected: true, Authenticated: true, Permissions: []string{}},
+		{Method: "HEAD", Path: "/private", Protected: true, Authenticated: true, Permissions: []string{}},
+		{Method: "GET", Path: "/private/users", Protected: true, Authenticated: true, Permissions: []string{"users.read"}},
+		{Method: "POST", Path: "/private/users", Protected: true, Authenticated: true, Permissions: []string{"users.read", "users.write"}},
+		{Method: "GET", Path: "/public", Permissions: []string{}},
+		{Method: "GET", Path: "/tenants/{tenant}/users", Protected: true, Authenticated: true, Permissions: []string{"users.read"}, TenantScoped: true},
+	}, registry.Routes())
+	require.Equal(t, []string{"users.read", "users.write"}, registry.Permissions())
+}
+
+func TestRegistryHandler(t *testing.T) {
+	registry, err := NewRegistry(appRouter())
+	require.Nil(t, err)
+
+	rw := httptest.NewRecorder()
+	registry.ServeHTTP(rw, httptest.NewRequest("GET", "http://example.com/_auth/routes", nil))
+	res := rw.Result()
+	require.Equal(t, 200, res.StatusCode)
+
+	var out struct {
+		Routes []Route `json:"routes"`
+	}
+	require.Nil(t, jsonio.UnmarshalResponse(res, &out))
+	require.Equal(t, registry.Routes(), out.Routes)
+}
@@ -0,0 +1,68 @@
+package authmux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// UnprotectedRoutesError is returned by `Verify` when routes are found which are
+// neither protected by an auth authorizer, nor allowed to be public.
+type UnprotectedRoutesError struct {
+	routes []Route
+}
+
+// Routes returns the unprotected routes
+func (e *UnprotectedRoutesError) Routes() []Route {
+	return e.routes
+}
+
+func (e *UnprotectedRoutesError) Error() string {
+	list := make([]string, len(e.routes))
+	for i, route := range e.routes {
+		list[i] = route.Method + " " + route.Path
+	}
+	return fmt.Sprintf("%d unprotected routes: %s", len(list), strings.Join(list, ", "))
+}
+
+// Verify walks the router and returns an `*UnprotectedRoutesError` listing every
+// route whose handler isn't protected by an authorizer from the auth package, unless
+// it is in the public allowlist.  It fails closed, so routes wrapped by other
+// middlewares on top of an authorizer are reported too, and must be reordered or
+// allowlisted.  It's intended to be called from a unit test, or at startup before
+// serving requests:
+//
+//	if err := authmux.Verify(router, "GET /health", "/login"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// Allowlist entries are path templates as registered with the router, optionally
+// prefixed with a method and a space.  A path without a method allows every method.
+func Verify(router *mux.Router, public ...string) error {
+	registry, err := NewRegistry(router)
+	if err != nil {
+		return err
+	}
+
+	allowed := map[string]bool{}
+	for _, entry := range public {
+		allowed[entry] = true
+	}
+
+	var unprotected []Route
+	for _, route := range registry.Routes() {
+		if route.Protected || allowed[route.Path] || allowed[route.Method+" "+route.Path] {
+			continue
+		}
+		unprotected = append(unprotected, route)
+	}
+	if len(unprotected) == 0 {
+		return nil
+	}
+	sort.SliceStable(unprotected, func(i, j int) bool {
+		return unprotected[i].Path < unprotected[j].Path
+	})
+	return &UnprotectedRoutesError{unprotected}
+}
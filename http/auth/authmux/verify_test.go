@@ -0,0 +1,47 @@
+package authmux
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+func TestVerify(t *testing.T) {
+	router := appRouter()
+	require.Nil(t, Verify(router, "/public"))
+	require.Nil(t, Verify(router, "GET /public"))
+
+	err := Verify(router)
+	require.NotNil(t, err)
+	require.Equal(t, "1 unprotected routes: GET /public", err.Error())
+
+	err = Verify(router, "POST /public")
+	require.NotNil(t, err)
+
+	router.HandleFunc("/forgotten", appHandler).Methods("PUT", "DELETE")
+	err = Verify(router, "/public")
+	require.NotNil(t, err)
+	unprotected, ok := err.(*UnprotectedRoutesError)
+	require.True(t, ok)
+	require.Equal(t, []Route{
+		{Method: "DELETE", Path: "/forgotten", Permissions: []string{}},
+		{Method: "PUT", Path: "/forgotten", Permissions: []string{}},
+	}, unprotected.Routes())
+}
+
+func TestVerifyFailsClosed(t *testing.T) {
+	router := appRouter()
+	wrapped := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, r)
+		})
+	}
+	authClient := auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)
+	router.Handle("/wrapped", wrapped(authClient(http.HandlerFunc(appHandler))))
+	err := Verify(router, "/public")
+	require.NotNil(t, err)
+	require.Equal(t, "1 unprotected routes: * /wrapped", err.Error())
+}
@@ -0,0 +1,133 @@
+// Package authtransport provides an `http.RoundTripper` which adds credentials to
+// outgoing requests, for use by services calling each other.  Credentials can be
+// static api keys or bearer tokens, OAuth2 client credentials tokens, or the
+// credentials of an incoming request being proxied.
+package authtransport
+
+import (
+	"context"
+	"net/http"
+)
+
+// CredentialSource returns the value of the `Authorization` header to send with an
+// outgoing request.  An empty string means no header should be sent.
+type CredentialSource interface {
+	Authorization(r *http.Request) (string, error)
+}
+
+// CredentialSourceFunc allows a plain function to be used as a CredentialSource
+type CredentialSourceFunc func(r *http.Request) (string, error)
+
+// Authorization implements CredentialSource
+func (f CredentialSourceFunc) Authorization(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// APIKey returns a CredentialSource which sends a static api key with the given
+// scheme, matching the format expected by `apikeyauth.NewAPIKeyAuthenticator`.
+func APIKey(scheme, key string) CredentialSource {
+	return CredentialSourceFunc(func(*http.Request) (string, error) {
+		return scheme + " " + key, nil
+	})
+}
+
+// Bearer returns a CredentialSource which sends a static bearer token
+func Bearer(token string) CredentialSource {
+	return APIKey("Bearer", token)
+}
+
+// Forward returns a CredentialSource which sends the credentials of an incoming
+// request, stored in the context at the specified key by
+// `NewCredentialCapture`.  The outgoing request must carry the context of the
+// incoming request, for example via `http.Request.WithContext`.
+func Forward(contextKey string) CredentialSource {
+	return CredentialSourceFunc(func(r *http.Request) (string, error) {
+		creds, _ := r.Context().Value(contextKey).(string)
+		return creds, nil
+	})
+}
+
+// NewCredentialCapture returns a middleware which stores the `Authorization`
+// header of incoming requests in the request context at the specified key, so
+// that it can be forwarded with `Forward`.
+func NewCredentialCapture(contextKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, captureCredentials(contextKey, r))
+		})
+	}
+}
+
+// NewCredentialCaptureMiddleware returns a negroni-style middleware which stores
+// the `Authorization` header of incoming requests in the request context at the
+// specified key, so that it can be forwarded with `Forward`.
+func NewCredentialCaptureMiddleware(contextKey string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(rw, captureCredentials(contextKey, r))
+	}
+}
+
+func captureCredentials(contextKey string, r *http.Request) *http.Request {
+	creds := r.Header.Get("Authorization")
+	if creds == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), contextKey, creds))
+}
+
+// Transport is an `http.RoundTripper` which sets the `Authorization` header of
+// outgoing requests from a CredentialSource.
+type Transport struct {
+	// Source of credentials for each request
+	Source CredentialSource
+	// Base is the RoundTripper used to make requests, defaults to
+	// `http.DefaultTransport`
+	Base http.RoundTripper
+}
+
+// NewTransport returns a Transport which adds credentials from the source to
+// requests made with the base RoundTripper.
+func NewTransport(source CredentialSource, base http.RoundTripper) *Transport {
+	return &Transport{Source: source, Base: base}
+}
+
+// NewClient returns an `http.Client` which adds credentials from the source to
+// any requests made.
+func NewClient(source CredentialSource) *http.Client {
+	return &http.Client{Transport: NewTransport(source, nil)}
+}
+
+// RoundTrip implements `http.RoundTripper`
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.Source.Authorization(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// round trippers must not modify the request they are given
+	if creds != "" {
+		req = cloneRequest(req)
+		req.Header.Set("Authorization", creds)
+	}
+	return t.base().RoundTrip(req)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func cloneRequest(r *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		r2.Header[k] = append([]string(nil), v...)
+	}
+	return r2
+}
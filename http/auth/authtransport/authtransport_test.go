@@ -0,0 +1,184 @@
+package authtransport
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer responds with the Authorization header it received
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.Header.Get("Authorization")))
+	}))
+}
+
+func get(t *testing.T, client *http.Client, req *http.Request) string {
+	res, err := client.Do(req)
+	require.Nil(t, err)
+	out, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	return string(out)
+}
+
+func TestStaticCredentials(t *testing.T) {
+	ts := echoServer()
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	require.Equal(t, "Key good-key", get(t, NewClient(APIKey("Key", "good-key")), req))
+	require.Equal(t, "", req.Header.Get("Authorization"))
+
+	req, _ = http.NewRequest("GET", ts.URL, nil)
+	require.Equal(t, "Bearer good-token", get(t, NewClient(Bearer("good-token")), req))
+}
+
+func TestForward(t *testing.T) {
+	upstream := echoServer()
+	defer upstream.Close()
+
+	// a proxy which forwards incoming credentials to the upstream service
+	client := NewClient(Forward("Credentials"))
+	proxy := httptest.NewServer(NewCredentialCapture("Credentials")(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		rw.Write([]byte(get(t, client, req.WithContext(r.Context()))))
+	})))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("Authorization", "Key good-key")
+	require.Equal(t, "Key good-key", get(t, http.DefaultClient, req))
+
+	req, _ = http.NewRequest("GET", proxy.URL, nil)
+	require.Equal(t, "", get(t, http.DefaultClient, req))
+}
+
+func TestClientCredentials(t *testing.T) {
+	var mu sync.Mutex
+	issued := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "secret" || r.PostFormValue("grant_type") != "client_credentials" {
+			jsonio.Respond(rw, 401, map[string]string{"error": "invalid_client"})
+			return
+		}
+		mu.Lock()
+		issued++
+		token := fmt.Sprintf("token-%d-%s", issued, r.PostFormValue("scope"))
+		mu.Unlock()
+		jsonio.Respond(rw, 200, map[string]interface{}{"access_token": token, "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+	ts := echoServer()
+	defer ts.Close()
+
+	t.Run("tokens cached and refreshed", func(t *testing.T) {
+		issued = 0
+		creds := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret", Scopes: []string{"users.read", "users.write"}}
+		client := NewClient(creds)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", ts.URL, nil)
+				require.Equal(t, "Bearer token-1-users.read users.write", get(t, client, req))
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, 1, issued)
+
+		creds.Expire()
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		require.Equal(t, "Bearer token-2-users.read users.write", get(t, client, req))
+	})
+
+	t.Run("tokens refreshed near expiry", func(t *testing.T) {
+		issued = 0
+		creds := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret", ExpiryDelta: time.Hour}
+		_, err := creds.Token()
+		require.Nil(t, err)
+		tok, err := creds.Token()
+		require.Nil(t, err)
+		require.Equal(t, "token-2-", tok)
+	})
+
+	t.Run("token request fails", func(t *testing.T) {
+		creds := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "wrong"}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		_, err := NewClient(creds).Do(req)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "token request failed with status 401")
+	})
+}
+
+func TestClientCredentialsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	// a hung token endpoint fails the request rather than blocking it forever
+	creds := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret", Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	_, err := creds.Token()
+	require.NotNil(t, err)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestClientCredentialsCancel(t *testing.T) {
+	received, release := make(chan struct{}, 1), make(chan struct{})
+	var mu sync.Mutex
+	issued := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		issued++
+		n := issued
+		mu.Unlock()
+		if n == 1 {
+			received <- struct{}{}
+			<-release
+			return
+		}
+		jsonio.Respond(rw, 200, map[string]interface{}{"access_token": fmt.Sprintf("token-%d", n), "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+	creds := &ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "secret", Timeout: 5 * time.Second}
+
+	// the token request is cancelled with the outgoing request which needed it
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := creds.TokenContext(ctx)
+		errs <- err
+	}()
+	<-received
+
+	// without blocking other callers while it's in flight
+	creds.Expire()
+	var tok string
+	go func() {
+		var err error
+		tok, err = creds.Token()
+		errs <- err
+	}()
+
+	cancel()
+	require.NotNil(t, <-errs)
+
+	// and others waiting on it make their own request
+	require.Nil(t, <-errs)
+	require.Equal(t, "token-2", tok)
+}
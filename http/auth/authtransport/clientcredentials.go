@@ -0,0 +1,192 @@
+package authtransport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultExpiryDelta is how long before expiry tokens are refreshed by default
+	defaultExpiryDelta = 10 * time.Second
+	// defaultTokenTimeout bounds token requests by default
+	defaultTokenTimeout = 10 * time.Second
+)
+
+// ClientCredentials is a CredentialSource which sends bearer tokens obtained from
+// an OAuth2 token endpoint using the client credentials grant.  Tokens are cached
+// until shortly before they expire.  It is safe for concurrent use, and
+// concurrent requests needing a new token will wait on a single refresh.
+type ClientCredentials struct {
+	// TokenURL is the token endpoint of the authorization server
+	TokenURL string
+	// ClientID and ClientSecret authenticate the client to the token endpoint
+	ClientID     string
+	ClientSecret string
+	// Scopes requested for the token, if any
+	Scopes []string
+	// Client is used for token requests, defaults to `http.DefaultClient`
+	Client *http.Client
+	// ExpiryDelta is how long before expiry a token is refreshed, defaults to
+	// 10 seconds
+	ExpiryDelta time.Duration
+	// Timeout bounds each token request, defaults to 10 seconds.  Token requests
+	// are also cancelled with the outgoing request which needed the token.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	token    string
+	expires  time.Time
+	inflight *tokenFetch
+}
+
+// tokenFetch is an in-flight token request, which concurrent requests wait on
+type tokenFetch struct {
+	done chan struct{}
+	err  error
+	// cancelled is set if the request was cancelled by the context of the
+	// outgoing request which made it, so that others waiting should retry
+	cancelled bool
+}
+
+// tokenResponse is the successful response of an OAuth2 token endpoint
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Authorization implements CredentialSource
+func (c *ClientCredentials) Authorization(r *http.Request) (string, error) {
+	token, err := c.TokenContext(r.Context())
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// Token returns a valid access token, fetching a new one if needed
+func (c *ClientCredentials) Token() (string, error) {
+	return c.TokenContext(context.Background())
+}
+
+// TokenContext returns a valid access token, fetching a new one if needed.  If
+// another call is already fetching a token, it waits for that one.  The context
+// cancels the wait, and the request if this call makes it.
+func (c *ClientCredentials) TokenContext(ctx context.Context) (string, error) {
+	delta := c.ExpiryDelta
+	if delta == 0 {
+		delta = defaultExpiryDelta
+	}
+	for {
+		c.mu.Lock()
+		if c.token != "" && (c.expires.IsZero() || time.Now().Add(delta).Before(c.expires)) {
+			token := c.token
+			c.mu.Unlock()
+			return token, nil
+		}
+		f := c.inflight
+		if f == nil {
+			f = &tokenFetch{done: make(chan struct{})}
+			c.inflight = f
+			c.mu.Unlock()
+			return c.refresh(ctx, f)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if f.err != nil && !f.cancelled {
+			return "", f.err
+		}
+	}
+}
+
+// refresh makes the token request for the in-flight fetch
+func (c *ClientCredentials) refresh(ctx context.Context, f *tokenFetch) (string, error) {
+	tok, err := c.fetch(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		c.token = tok.AccessToken
+		c.expires = time.Time{}
+		if tok.ExpiresIn > 0 {
+			c.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+		}
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+	f.err = err
+	f.cancelled = err != nil && ctx.Err() != nil
+	close(f.done)
+
+	if err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
+
+// Expire forces a new token to be fetched on the next request, for example if the
+// current token was rejected.
+func (c *ClientCredentials) Expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+}
+
+func (c *ClientCredentials) fetch(ctx context.Context) (*tokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTokenTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("token request failed with status %d: %s", res.StatusCode, body)
+	}
+
+	var tok tokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, err
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("token response did not include an access token")
+	}
+	return &tok, nil
+}
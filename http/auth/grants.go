@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrGrantExpired is returned when a permission was granted, but the grant has
+// since expired, so apps can tell users their access has ended rather than that
+// it was never granted.
+type ErrGrantExpired struct {
+	perm    string
+	expired time.Time
+}
+
+func (e ErrGrantExpired) Error() string {
+	return "grant expired: " + e.perm
+}
+
+// Permission returns the name of the permission whose grant expired
+func (e ErrGrantExpired) Permission() string {
+	return e.perm
+}
+
+// ExpiredAt returns when the grant expired
+func (e ErrGrantExpired) ExpiredAt() time.Time {
+	return e.expired
+}
+
+// RequestAuthorizer is implemented by objects whose permissions depend on the
+// request being made.  The permissions authorizer prefers it to Authorizer when
+// available.  AuthorizeRequest returns nil if the permission is granted for the
+// request, and otherwise an error such as ErrPermissionDenied or ErrGrantExpired.
+type RequestAuthorizer interface {
+	AuthorizeRequest(r *http.Request, perm string) error
+}
+
+// Condition restricts the requests a grant applies to
+type Condition func(r *http.Request, now time.Time) bool
+
+// FromNetworks returns a Condition which only allows requests from client IPs in
+// the CIDR ranges, such as `10.0.0.0/8`.  Client IPs are determined by `RemoteIP`.
+func FromNetworks(cidrs ...string) (Condition, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return func(r *http.Request, now time.Time) bool {
+		ip := net.ParseIP(RemoteIP(r))
+		if ip == nil {
+			return false
+		}
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// WithMethods returns a Condition which only allows requests with the HTTP methods
+func WithMethods(methods ...string) Condition {
+	return func(r *http.Request, now time.Time) bool {
+		for _, method := range methods {
+			if strings.EqualFold(r.Method, method) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// DuringHours returns a Condition which only allows requests made between the
+// start and end times of day in the location, given as offsets from midnight.  The
+// window may span midnight, e.g. from 22:00 until 06:00 for on-call access.
+func DuringHours(start, end time.Duration, loc *time.Location) Condition {
+	return func(r *http.Request, now time.Time) bool {
+		local := now.In(loc)
+		sinceMidnight := time.Duration(local.Hour())*time.Hour +
+			time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second
+		if start <= end {
+			return sinceMidnight >= start && sinceMidnight < end
+		}
+		return sinceMidnight >= start || sinceMidnight < end
+	}
+}
+
+// Grant grants a permission for a window of time, optionally only to requests
+// meeting conditions.
+type Grant struct {
+	Permission string
+	// NotBefore is when the grant becomes valid, or zero if it's valid
+	// immediately
+	NotBefore time.Time
+	// NotAfter is when the grant expires, or zero if it never does
+	NotAfter time.Time
+	// Conditions which must all allow a request for the grant to apply
+	Conditions []Condition
+}
+
+func (g Grant) active(now time.Time) bool {
+	return (g.NotBefore.IsZero() || !now.Before(g.NotBefore)) && !g.expired(now)
+}
+
+func (g Grant) expired(now time.Time) bool {
+	return !g.NotAfter.IsZero() && !now.Before(g.NotAfter)
+}
+
+func (g Grant) allows(r *http.Request, now time.Time) bool {
+	for _, cond := range g.Conditions {
+		if !cond(r, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewGrantedClient returns a new GrantedClient with the specified id and grants
+func NewGrantedClient(id string, grants []Grant) GrantedClient {
+	return GrantedClient{id, grants}
+}
+
+// GrantedClient implements the Authenticator, Authorizer and RequestAuthorizer
+// interfaces with permissions granted by time bound and conditional grants.
+type GrantedClient struct {
+	id     string
+	grants []Grant
+}
+
+func (g GrantedClient) AuthenticationID() string {
+	return g.id
+}
+
+// Grants returns the client's grants
+func (g GrantedClient) Grants() []Grant {
+	return g.grants
+}
+
+// HasPermission grants permissions with an active grant which has no conditions,
+// as conditions can't be evaluated without a request.
+func (g GrantedClient) HasPermission(perm string) (bool, error) {
+	now := time.Now()
+	for _, grant := range g.grants {
+		if grant.Permission == perm && grant.active(now) && len(grant.Conditions) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AuthorizeRequest grants permissions with an active grant whose conditions allow
+// the request.  If the only grants which would have applied have expired, it
+// returns ErrGrantExpired.
+func (g GrantedClient) AuthorizeRequest(r *http.Request, perm string) error {
+	now := time.Now()
+	var expired time.Time
+	for _, grant := range g.grants {
+		if grant.Permission != perm || !grant.allows(r, now) {
+			continue
+		}
+		if grant.active(now) {
+			return nil
+		}
+		if grant.expired(now) && grant.NotAfter.After(expired) {
+			expired = grant.NotAfter
+		}
+	}
+	if !expired.IsZero() {
+		return ErrGrantExpired{perm, expired}
+	}
+	return ErrPermissionDenied{perm}
+}
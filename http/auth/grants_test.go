@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditions(t *testing.T) {
+	office, err := FromNetworks("10.0.0.0/8", "2001:db8::/32")
+	require.Nil(t, err)
+	_, err = FromNetworks("10.0.0.0")
+	require.NotNil(t, err)
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	now := time.Now()
+	r.RemoteAddr = "10.1.2.3:1234"
+	require.True(t, office(r, now))
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	require.True(t, office(r, now))
+	r.RemoteAddr = "192.0.2.1:1234"
+	require.False(t, office(r, now))
+
+	readOnly := WithMethods("GET", "HEAD")
+	require.True(t, readOnly(r, now))
+	require.False(t, readOnly(httptest.NewRequest("POST", "http://example.com/", nil), now))
+
+	loc := time.FixedZone("EST", -5*60*60)
+	at := func(hour int) time.Time {
+		return time.Date(2018, 3, 1, hour, 30, 0, 0, loc)
+	}
+	business := DuringHours(9*time.Hour, 17*time.Hour, loc)
+	require.True(t, business(r, at(9)))
+	require.False(t, business(r, at(17)))
+	require.False(t, business(r, at(8)))
+	// evaluated in the location, not the time's own zone
+	require.True(t, business(r, at(12).UTC()))
+
+	overnight := DuringHours(22*time.Hour, 6*time.Hour, loc)
+	require.True(t, overnight(r, at(23)))
+	require.True(t, overnight(r, at(2)))
+	require.False(t, overnight(r, at(12)))
+}
+
+func TestGrantedClient(t *testing.T) {
+	now := time.Now()
+	office, _ := FromNetworks("10.0.0.0/8")
+	client := NewGrantedClient("contractor-1", []Grant{
+		{Permission: "users.read"},
+		{Permission: "users.write", NotAfter: now.Add(-time.Hour)},
+		{Permission: "deploys.create", NotBefore: now.Add(time.Hour)},
+		{Permission: "db.write", NotAfter: now.Add(time.Hour), Conditions: []Condition{office}},
+	})
+
+	h := NewPermissionsAuthorizer("ApiClient", StandardErrorHandler)
+	call := func(perm, remoteAddr string) (int, string) {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = remoteAddr
+		r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		rw := httptest.NewRecorder()
+		h(http.HandlerFunc(handler), perm).ServeHTTP(rw, r)
+		return rw.Code, rw.Body.String()
+	}
+
+	code, _ := call("users.read", "192.0.2.1:1234")
+	require.Equal(t, 200, code)
+	code, body := call("users.write", "192.0.2.1:1234")
+	require.Equal(t, 403, code)
+	require.Equal(t, "Access expired", body)
+	code, body = call("deploys.create", "192.0.2.1:1234")
+	require.Equal(t, 403, code)
+	require.Equal(t, "Access denied", body)
+	code, _ = call("db.write", "10.1.2.3:1234")
+	require.Equal(t, 200, code)
+	code, _ = call("db.write", "192.0.2.1:1234")
+	require.Equal(t, 403, code)
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	err := client.AuthorizeRequest(r, "users.write")
+	expired, ok := err.(ErrGrantExpired)
+	require.True(t, ok)
+	require.Equal(t, "users.write", expired.Permission())
+	require.Equal(t, now.Add(-time.Hour), expired.ExpiredAt())
+
+	// conditional grants can't be evaluated without a request
+	allowed, _ := client.HasPermission("users.read")
+	require.True(t, allowed)
+	allowed, _ = client.HasPermission("db.write")
+	require.False(t, allowed)
+}
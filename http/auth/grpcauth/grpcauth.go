@@ -0,0 +1,157 @@
+// Package grpcauth provides gRPC server interceptors using the same auth model as
+// the http middlewares: credentials are validated by an
+// `apikeyauth.APIKeyAuthenticator`, the returned principal is stored in the
+// context, and permissions are checked through the `auth.Authorizer` interface.
+//
+// Unlike the http middlewares, authentication and authorization happen in a single
+// interceptor, which fails closed: every method requires an authenticated
+// principal unless it is explicitly public.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+)
+
+// ErrorHandler converts errors from authentication or authorization into the error
+// returned to the client, which should be a gRPC status error.
+type ErrorHandler func(ctx context.Context, err error) error
+
+// StandardErrorHandler maps the errors defined in the auth package to gRPC status
+// codes, and any other errors to `Internal`.
+func StandardErrorHandler(ctx context.Context, err error) error {
+	if err == auth.ErrAuthenticationRequired {
+		return status.Error(codes.Unauthenticated, "Authentication required")
+	}
+	if err == auth.ErrAuthorizationFailed {
+		return status.Error(codes.PermissionDenied, "Access denied")
+	}
+	if _, ok := err.(auth.ErrPermissionDenied); ok {
+		return status.Error(codes.PermissionDenied, "Access denied")
+	}
+	if _, ok := err.(auth.ErrGrantExpired); ok {
+		return status.Error(codes.PermissionDenied, "Access expired")
+	}
+	if _, ok := err.(auth.ErrStepUpRequired); ok {
+		return status.Error(codes.Unauthenticated, "Second factor required")
+	}
+	if _, ok := err.(auth.ErrTooManyRequests); ok {
+		return status.Error(codes.ResourceExhausted, "Too many requests")
+	}
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// Config configures the interceptors
+type Config struct {
+	// Keyname is the scheme expected before the credential in the `authorization`
+	// metadata, e.g. `ApiKey` or `Bearer`
+	Keyname string
+	// ContextKey the principal is stored at
+	ContextKey string
+	// Authenticate validates credentials, and returns the principal
+	Authenticate apikeyauth.APIKeyAuthenticator
+	// Permissions required by each method, by full method name, e.g.
+	// `/users.v1.Users/Delete`.  Methods not listed only require an
+	// authenticated principal.
+	Permissions map[string][]string
+	// Public methods don't require authentication, though credentials are still
+	// authenticated if sent
+	Public []string
+	// ErrorHandler defaults to StandardErrorHandler
+	ErrorHandler ErrorHandler
+}
+
+// NewUnaryServerInterceptor creates a unary server interceptor which authenticates
+// and authorizes each call according to the config.
+func NewUnaryServerInterceptor(config Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := check(ctx, info.FullMethod, config)
+		if err != nil {
+			return nil, fail(ctx, err, config)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewStreamServerInterceptor creates a stream server interceptor which
+// authenticates and authorizes each stream according to the config.
+func NewStreamServerInterceptor(config Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := check(ss.Context(), info.FullMethod, config)
+		if err != nil {
+			return fail(ctx, err, config)
+		}
+		return handler(srv, &serverStream{ss, ctx})
+	}
+}
+
+// serverStream overrides the context of a stream with one carrying the principal
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func fail(ctx context.Context, err error, config Config) error {
+	if config.ErrorHandler != nil {
+		return config.ErrorHandler(ctx, err)
+	}
+	return StandardErrorHandler(ctx, err)
+}
+
+func check(ctx context.Context, method string, config Config) (context.Context, error) {
+	ctx, err := authenticate(ctx, config)
+	if err != nil {
+		return ctx, err
+	}
+	for _, public := range config.Public {
+		if method == public {
+			return ctx, nil
+		}
+	}
+
+	principal := ctx.Value(config.ContextKey)
+	if err := auth.CheckClient(principal); err != nil {
+		return ctx, err
+	}
+	if perms := config.Permissions[method]; len(perms) > 0 {
+		if err := auth.CheckPermissions(principal, perms...); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func authenticate(ctx context.Context, config Config) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md["authorization"]) == 0 {
+		return ctx, nil
+	}
+
+	// no credential for this scheme sent, continue on
+	parts := strings.Split(md["authorization"][0], " ")
+	if len(parts) != 2 || parts[0] != config.Keyname {
+		return ctx, nil
+	}
+
+	obj, err := config.Authenticate(parts[1])
+	if err != nil {
+		return ctx, err
+	}
+	if obj == nil {
+		return ctx, errors.New("authenticator returned nil, should return error instead")
+	}
+	return context.WithValue(ctx, config.ContextKey, obj), nil
+}
@@ -0,0 +1,118 @@
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+func authenticateKey(key string) (interface{}, error) {
+	switch key {
+	case "reader":
+		return auth.NewBasicApiClient("reader", []string{"users.read"}), nil
+	case "writer":
+		return auth.NewBasicApiClient("writer", []string{"users.read", "users.write"}), nil
+	case "broken":
+		return nil, errors.New("database unavailable")
+	}
+	return nil, auth.ErrAuthenticationRequired
+}
+
+var config = Config{
+	Keyname:      "ApiKey",
+	ContextKey:   "ApiClient",
+	Authenticate: authenticateKey,
+	Permissions: map[string][]string{
+		"/users.v1.Users/Get":    {"users.read"},
+		"/users.v1.Users/Delete": {"users.write"},
+	},
+	Public: []string{"/grpc.health.v1.Health/Check"},
+}
+
+func incoming(authorization string) context.Context {
+	if authorization == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", authorization))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := NewUnaryServerInterceptor(config)
+	call := func(method, authorization string) codes.Code {
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if method != "/grpc.health.v1.Health/Check" {
+				_, ok := ctx.Value("ApiClient").(auth.Authenticator)
+				require.True(t, ok)
+			}
+			return "ok", nil
+		}
+		res, err := interceptor(incoming(authorization), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if err == nil {
+			require.Equal(t, "ok", res)
+		}
+		return status.Code(err)
+	}
+
+	tests := []struct {
+		method        string
+		authorization string
+		expected      codes.Code
+	}{
+		{"/grpc.health.v1.Health/Check", "", codes.OK},
+		{"/grpc.health.v1.Health/Check", "ApiKey invalid", codes.Unauthenticated},
+		{"/users.v1.Users/List", "", codes.Unauthenticated},
+		{"/users.v1.Users/List", "Bearer reader", codes.Unauthenticated},
+		{"/users.v1.Users/List", "ApiKey reader", codes.OK},
+		{"/users.v1.Users/Get", "ApiKey reader", codes.OK},
+		{"/users.v1.Users/Delete", "ApiKey reader", codes.PermissionDenied},
+		{"/users.v1.Users/Delete", "ApiKey writer", codes.OK},
+		{"/users.v1.Users/Delete", "ApiKey broken", codes.Internal},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, call(test.method, test.authorization), "%s %s", test.method, test.authorization)
+	}
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s testStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := NewStreamServerInterceptor(config)
+	call := func(method, authorization string) error {
+		info := &grpc.StreamServerInfo{FullMethod: method, IsServerStream: true}
+		return interceptor(nil, testStream{ctx: incoming(authorization)}, info, func(srv interface{}, ss grpc.ServerStream) error {
+			client, ok := ss.Context().Value("ApiClient").(auth.Authenticator)
+			require.True(t, ok)
+			require.Equal(t, "writer", client.AuthenticationID())
+			return nil
+		})
+	}
+
+	require.Nil(t, call("/users.v1.Users/Delete", "ApiKey writer"))
+	require.Equal(t, codes.PermissionDenied, status.Code(call("/users.v1.Users/Delete", "ApiKey reader")))
+	require.Equal(t, codes.Unauthenticated, status.Code(call("/users.v1.Users/Watch", "")))
+}
+
+func TestCustomErrorHandler(t *testing.T) {
+	c := config
+	c.ErrorHandler = func(ctx context.Context, err error) error {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	interceptor := NewUnaryServerInterceptor(c)
+	_, err := interceptor(incoming(""), nil, &grpc.UnaryServerInfo{FullMethod: "/users.v1.Users/List"}, nil)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
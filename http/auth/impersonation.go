@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ImpersonationResolver receives the principal making a request and the id of the
+// principal it wants to act as, and is expected to return the object describing
+// that principal.  If an error is returned, it's encouraged to return one of the
+// errors defined in this package.
+type ImpersonationResolver func(actor interface{}, subjectID string) (interface{}, error)
+
+// ImpersonationAuditor is called for every request made in an impersonated
+// session, with both the actor making the request and the subject being
+// impersonated.
+type ImpersonationAuditor func(r *http.Request, actor, subject interface{})
+
+// ImpersonationConfig configures the impersonation middleware
+type ImpersonationConfig struct {
+	// Permission the actor must be granted in order to impersonate others
+	Permission string
+	// Header containing the id of the subject to act as, defaults to `Act-As`
+	Header string
+	// ActorContextKey is the context key the actor is stored at during an
+	// impersonated session.  It's required, and must differ from the key the
+	// principal is stored at.
+	ActorContextKey string
+	// AllowedPermissions restricts impersonated sessions to a subset of the
+	// subject's permissions
+	AllowedPermissions []string
+	// Resolve returns the subject being impersonated
+	Resolve ImpersonationResolver
+	// Audit hooks called for every impersonated request
+	Audit []ImpersonationAuditor
+}
+
+// Impersonation is stored in the request context in place of the actor during an
+// impersonated session.  It identifies as the subject, but only grants the
+// subject's permissions which are allowed for impersonated sessions.  It
+// implements the Authenticator, Authorizer and PermissionLister interfaces.
+type Impersonation struct {
+	actor   interface{}
+	subject interface{}
+	allowed []string
+}
+
+// Actor returns the principal making the request
+func (i Impersonation) Actor() interface{} {
+	return i.actor
+}
+
+// Subject returns the principal being impersonated
+func (i Impersonation) Subject() interface{} {
+	return i.subject
+}
+
+// AuthenticationID returns the id of the subject
+func (i Impersonation) AuthenticationID() string {
+	if a, ok := i.subject.(Authenticator); ok {
+		return a.AuthenticationID()
+	}
+	return ""
+}
+
+// HasPermission grants permissions which are granted to the subject, and allowed
+// for impersonated sessions.
+func (i Impersonation) HasPermission(perm string) (bool, error) {
+	if !contains(i.allowed, perm) {
+		return false, nil
+	}
+	if a, ok := i.subject.(Authorizer); ok {
+		return a.HasPermission(perm)
+	}
+	return false, nil
+}
+
+// Permissions lists the permissions granted to the subject which are allowed for
+// impersonated sessions
+func (i Impersonation) Permissions() []string {
+	var perms []string
+	for _, perm := range i.allowed {
+		if granted, err := i.HasPermission(perm); err == nil && granted {
+			perms = append(perms, perm)
+		}
+	}
+	return perms
+}
+
+// NewImpersonator returns a middleware which allows principals granted the
+// configured permission to act as another principal, identified by a request
+// header.  The principal found in the request context at the specified key is
+// replaced with an Impersonation, and the original principal is stored at
+// `ImpersonationConfig.ActorContextKey`.  Requests without the header are
+// unaffected.  It panics if the actor context key is missing or the same as the
+// principal's, as the actor would be lost.
+func NewImpersonator(contextKey string, failFn ErrorHandler, config ImpersonationConfig) func(http.Handler) http.Handler {
+	config.validate(contextKey)
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := impersonate(contextKey, config, r)
+			if err != nil {
+				failFn(rw, req, err)
+				return
+			}
+			handler.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// NewImpersonatorMiddleware returns a negroni-style middleware which allows
+// principals granted the configured permission to act as another principal.  See
+// `NewImpersonator` for details.
+func NewImpersonatorMiddleware(contextKey string, failFn ErrorHandler, config ImpersonationConfig) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	config.validate(contextKey)
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		req, err := impersonate(contextKey, config, r)
+		if err != nil {
+			failFn(rw, req, err)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+func (c ImpersonationConfig) validate(contextKey string) {
+	if c.ActorContextKey == "" {
+		panic("auth: impersonation requires an ActorContextKey")
+	}
+	if c.ActorContextKey == contextKey {
+		panic("auth: impersonation ActorContextKey must differ from the principal's context key " + contextKey)
+	}
+}
+
+func impersonate(contextKey string, config ImpersonationConfig, r *http.Request) (*http.Request, error) {
+	header := config.Header
+	if header == "" {
+		header = "Act-As"
+	}
+	subjectID := r.Header.Get(header)
+	if subjectID == "" {
+		return r, nil
+	}
+
+	// the actor must be allowed to impersonate, and can't already be
+	// impersonating someone else
+	actor := r.Context().Value(contextKey)
+	if _, ok := actor.(Impersonation); ok {
+		return r, ErrAuthorizationFailed
+	}
+	if _, err := checkPermissions(contextKey, r, config.Permission); err != nil {
+		return r, err
+	}
+
+	subject, err := config.Resolve(actor, subjectID)
+	if err != nil {
+		return r, err
+	}
+	if subject == nil {
+		return r, errors.New("impersonation resolver returned nil, should return error instead")
+	}
+
+	for _, audit := range config.Audit {
+		audit(r, actor, subject)
+	}
+
+	ctx := context.WithValue(r.Context(), config.ActorContextKey, actor)
+	ctx = context.WithValue(ctx, contextKey, Impersonation{actor, subject, config.AllowedPermissions})
+	return r.WithContext(ctx), nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImpersonator(t *testing.T) {
+	customers := map[string]BasicApiClient{
+		"customer-1": NewBasicApiClient("customer-1", []string{"orders.read", "orders.write", "billing.write"}),
+	}
+	var audited [][2]string
+	impersonate := NewImpersonator("ApiClient", StandardErrorHandler, ImpersonationConfig{
+		Permission:         "support.impersonate",
+		ActorContextKey:    "Actor",
+		AllowedPermissions: []string{"orders.read", "orders.write"},
+		Resolve: func(actor interface{}, subjectID string) (interface{}, error) {
+			if c, ok := customers[subjectID]; ok {
+				return c, nil
+			}
+			return nil, ErrAuthorizationFailed
+		},
+		Audit: []ImpersonationAuditor{func(r *http.Request, actor, subject interface{}) {
+			audited = append(audited, [2]string{actor.(Authenticator).AuthenticationID(), subject.(Authenticator).AuthenticationID()})
+		}},
+	})
+	authPerms := NewPermissionsAuthorizer("ApiClient", StandardErrorHandler)
+	app := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		msg := "Hello " + r.Context().Value("ApiClient").(Authenticator).AuthenticationID()
+		if actor, ok := r.Context().Value("Actor").(Authenticator); ok {
+			msg += " via " + actor.AuthenticationID()
+		}
+		rw.Write([]byte(msg))
+	})
+
+	call := func(perm string, client interface{}, actAs string) (int, string) {
+		h := impersonate(authPerms(app, perm))
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if actAs != "" {
+			r.Header.Set("Act-As", actAs)
+		}
+		if client != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		res := rw.Result()
+		out, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(out)
+	}
+
+	support := NewBasicApiClient("support-1", []string{"support.impersonate", "orders.read"})
+	customer := customers["customer-1"]
+
+	tests := []struct {
+		name   string
+		perm   string
+		client interface{}
+		actAs  string
+		code   int
+		text   string
+	}{
+		{"no header", "orders.read", support, "", 200, "Hello support-1"},
+		{"impersonating", "orders.write", support, "customer-1", 200, "Hello customer-1 via support-1"},
+		{"permission outside allowed subset", "billing.write", support, "customer-1", 403, "Access denied"},
+		{"unknown subject", "orders.read", support, "customer-2", 403, "Access denied"},
+		{"actor without permission", "orders.read", customer, "customer-1", 403, "Access denied"},
+		{"anonymous actor", "orders.read", nil, "customer-1", 401, "Authentication required"},
+		{"nested impersonation", "orders.read", Impersonation{support, customer, []string{"support.impersonate"}}, "customer-1", 403, "Access denied"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, text := call(test.perm, test.client, test.actAs)
+			require.Equal(t, test.code, code)
+			require.Equal(t, test.text, text)
+		})
+	}
+
+	// both identities were audited for each impersonated request
+	require.Equal(t, [][2]string{{"support-1", "customer-1"}, {"support-1", "customer-1"}}, audited)
+}
+
+func TestNewImpersonatorActorContextKey(t *testing.T) {
+	// the actor would be lost without a separate key to store it at
+	require.Panics(t, func() {
+		NewImpersonator("ApiClient", StandardErrorHandler, ImpersonationConfig{Permission: "support.impersonate"})
+	})
+	require.Panics(t, func() {
+		NewImpersonatorMiddleware("ApiClient", StandardErrorHandler, ImpersonationConfig{Permission: "support.impersonate", ActorContextKey: "ApiClient"})
+	})
+	require.NotPanics(t, func() {
+		NewImpersonator("ApiClient", StandardErrorHandler, ImpersonationConfig{Permission: "support.impersonate", ActorContextKey: "Actor"})
+	})
+}
@@ -0,0 +1,206 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"errors"
+	"time"
+)
+
+// TokenPair is an access token and the refresh token which can be exchanged for
+// the next pair.  It marshals to the format of an OAuth2 token response.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Issuer mints signed access tokens and rotating refresh tokens.  Each time a
+// refresh token is exchanged, it is marked as used and a new one is issued in the
+// same family.  If a used refresh token is presented again, the whole family is
+// revoked, including any access tokens issued from it.
+type Issuer struct {
+	// Method used to sign tokens
+	Method SigningMethod
+	// KeyID, if set, is included in the `kid` header of tokens
+	KeyID string
+	// Issuer, if set, is included in the `iss` claim of tokens
+	Issuer string
+	// Audience, if set, is included in the `aud` claim of tokens
+	Audience string
+	// AccessTTL is how long access tokens are valid for
+	AccessTTL time.Duration
+	// RefreshTTL is how long refresh tokens are valid for
+	RefreshTTL time.Duration
+	// Store tracks revoked tokens and used refresh tokens
+	Store Store
+
+	now func() time.Time
+}
+
+// NewIssuer returns an Issuer signing tokens with the method, and tracking
+// revocations in the store.  Access tokens are valid for 15 minutes, and refresh
+// tokens for 30 days, unless changed.
+func NewIssuer(method SigningMethod, store Store) *Issuer {
+	return &Issuer{
+		Method:     method,
+		AccessTTL:  15 * time.Minute,
+		RefreshTTL: 30 * 24 * time.Hour,
+		Store:      store,
+		now:        time.Now,
+	}
+}
+
+// Verifier returns a Verifier for access tokens minted by the Issuer
+func (i *Issuer) Verifier() *Verifier {
+	return &Verifier{
+		KeyFunc:  StaticKey(i.Method),
+		Issuer:   i.Issuer,
+		Audience: i.Audience,
+		Store:    i.Store,
+	}
+}
+
+// IssueAccessToken returns an access token for the subject, granting the
+// permissions.  The token is not associated with a refresh token family.
+func (i *Issuer) IssueAccessToken(subject string, perms []string) (string, error) {
+	return i.issue(subject, perms, UseAccess, "", i.AccessTTL)
+}
+
+// IssueTokens returns an access token and a refresh token for the subject,
+// starting a new refresh token family.  This should be called when a principal
+// logs in.
+func (i *Issuer) IssueTokens(subject string, perms []string) (*TokenPair, error) {
+	family, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return i.issuePair(subject, perms, family)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens in the same family,
+// granting the same permissions.  Refresh tokens can only be used once.
+func (i *Issuer) Refresh(refreshToken string) (*TokenPair, error) {
+	if err := i.validate(); err != nil {
+		return nil, err
+	}
+	claims, err := i.verifyRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := i.Store.MarkUsed(claims.ID, claims.ExpiresAtTime())
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		// every token in the family expires within RefreshTTL from now
+		if err := i.Store.Revoke(claims.Family, i.clock().Add(i.RefreshTTL)); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return i.issuePair(claims.Subject, claims.Permissions, claims.Family)
+}
+
+// Revoke revokes a token minted by the Issuer.  Revoking a refresh token revokes
+// its whole family, which should be done when a principal logs out.
+func (i *Issuer) Revoke(token string) error {
+	if err := i.validate(); err != nil {
+		return err
+	}
+	var claims Claims
+	if _, err := Decode(token, StaticKey(i.Method), &claims); err != nil {
+		return err
+	}
+	if claims.Use == UseRefresh {
+		return i.Store.Revoke(claims.Family, i.clock().Add(i.RefreshTTL))
+	}
+	return i.Store.Revoke(claims.ID, claims.ExpiresAtTime())
+}
+
+func (i *Issuer) verifyRefreshToken(token string) (*Claims, error) {
+	var claims Claims
+	if _, err := Decode(token, StaticKey(i.Method), &claims); err != nil {
+		return nil, err
+	}
+	if claims.Use != UseRefresh || claims.Family == "" {
+		return nil, ErrInvalidToken
+	}
+	if err := i.Verifier().check(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (i *Issuer) issuePair(subject string, perms []string, family string) (*TokenPair, error) {
+	access, err := i.issue(subject, perms, UseAccess, family, i.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := i.issue(subject, perms, UseRefresh, family, i.RefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(i.AccessTTL / time.Second),
+	}, nil
+}
+
+func (i *Issuer) issue(subject string, perms []string, use, family string, ttl time.Duration) (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	now := i.clock()
+	claims := Claims{
+		ID:          id,
+		Issuer:      i.Issuer,
+		Subject:     subject,
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(ttl).Unix(),
+		Permissions: perms,
+		Use:         use,
+		Family:      family,
+	}
+	if i.Audience != "" {
+		claims.Audience = Audience{i.Audience}
+	}
+	return Encode(i.Method, i.KeyID, claims)
+}
+
+// validate checks that an Issuer created without `NewIssuer` is usable
+func (i *Issuer) validate() error {
+	switch {
+	case i.Method == nil:
+		return errors.New("issuer has no signing method")
+	case i.Store == nil:
+		return errors.New("issuer has no store")
+	case i.AccessTTL <= 0 || i.RefreshTTL <= 0:
+		return errors.New("issuer token lifetimes must be positive")
+	}
+	return nil
+}
+
+func (i *Issuer) clock() time.Time {
+	if i.now == nil {
+		return time.Now()
+	}
+	return i.now()
+}
+
+// newID returns a random identifier for the `jti` and `fam` claims
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b64.EncodeToString(b), nil
+}
@@ -0,0 +1,201 @@
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnknownKey is returned by a KeySet when a token refers to a key that
+// it doesn't contain
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JSONWebKey is a public key in the RFC 7517 JWK format.  Only RSA keys are
+// supported.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JSONWebKeySet is a set of keys in the RFC 7517 JWK Set format
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewRSAJSONWebKey returns the JWK for an RSA public key, for signing with RS256
+func NewRSAJSONWebKey(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   b64.EncodeToString(key.N.Bytes()),
+		E:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// SigningMethod returns the SigningMethod for verifying tokens with the key
+func (k JSONWebKey) SigningMethod() (SigningMethod, error) {
+	if k.Kty != "RSA" || (k.Alg != "" && k.Alg != "RS256") {
+		return nil, fmt.Errorf("unsupported key type %s %s", k.Kty, k.Alg)
+	}
+	n, err := b64.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := b64.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return RS256PublicKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}), nil
+}
+
+// KeySet is a set of verification keys fetched from a remote JWKS endpoint.  When
+// a token refers to an unknown key, the set is refreshed in case the keys have
+// been rotated.  It is safe for concurrent use, and concurrent lookups needing a
+// refresh wait on a single request.
+type KeySet struct {
+	// URL of the JWKS endpoint
+	URL string
+	// Client is used to fetch keys, defaults to `http.DefaultClient`
+	Client *http.Client
+	// MinRefreshInterval limits how often keys are refetched, whether or not
+	// the previous attempt succeeded, and defaults to one minute
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]SigningMethod
+	attempted time.Time
+	err       error
+	inflight  *keyFetch
+}
+
+// keyFetch is an in-flight request for the keys, which concurrent lookups wait on
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeySet returns a KeySet for the JWKS endpoint.  Keys are fetched when first
+// needed.
+func NewKeySet(url string) *KeySet {
+	return &KeySet{URL: url}
+}
+
+// KeyFunc returns the key identified by the `kid` header, and can be used as a
+// KeyFunc when verifying tokens.  If the keys were fetched less than
+// MinRefreshInterval ago, unknown keys fail without refetching, with the error
+// of the last attempt if it failed.
+func (k *KeySet) KeyFunc(h Header) (SigningMethod, error) {
+	k.mu.Lock()
+	if method, ok := k.keys[h.Kid]; ok {
+		k.mu.Unlock()
+		return method, nil
+	}
+
+	interval := k.MinRefreshInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	if k.inflight == nil && !k.attempted.IsZero() && time.Since(k.attempted) < interval {
+		err := k.err
+		k.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrUnknownKey
+	}
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if method, ok := k.keys[h.Kid]; ok {
+		return method, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Refresh refetches the keys from the JWKS endpoint
+func (k *KeySet) Refresh() error {
+	k.mu.Lock()
+	return k.refresh()
+}
+
+// refresh waits on the in-flight request for the keys, making one if needed.  It
+// must be called with the lock held, which it releases.
+func (k *KeySet) refresh() error {
+	if f := k.inflight; f != nil {
+		k.mu.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &keyFetch{done: make(chan struct{})}
+	k.inflight = f
+	k.mu.Unlock()
+
+	keys, err := k.fetch()
+
+	k.mu.Lock()
+	k.attempted = time.Now()
+	k.err = err
+	if err == nil {
+		k.keys = keys
+	}
+	k.inflight = nil
+	k.mu.Unlock()
+	f.err = err
+	close(f.done)
+	return err
+}
+
+func (k *KeySet) fetch() (map[string]SigningMethod, error) {
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(k.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("fetching keys failed with status %d", res.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+
+	// keys which aren't supported, or aren't for signatures, are skipped
+	keys := map[string]SigningMethod{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if method, err := key.SigningMethod(); err == nil {
+			keys[key.Kid] = method
+		}
+	}
+	return keys, nil
+}
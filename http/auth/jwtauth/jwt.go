@@ -1,13 +1,394 @@
+// Package jwtauth provides tools for issuing and verifying JSON Web Tokens, and an
+// authentication middleware for requests carrying them.
 package jwtauth
 
-/*
-type JwtAuthenticator func(token *jwt.Token) (ApiClient, error)
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
 
-// Look at https://github.com/auth0/go-jwt-middleware/blob/master/jwtmiddleware.go#L163
-// for inspiration
-func CreateJwtAuthenticator(keyname string, authFn JwtAuthenticator) http.HandlerFunc {
-	return func(rw http.ResponseWriter, r *http.Request) {
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
 
+var (
+	// ErrInvalidToken is returned when a token is malformed, or its signature
+	// is invalid
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired is returned when a token is expired, or not yet valid
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenRevoked is returned when a token has been revoked
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrRefreshTokenReused is returned when a refresh token which has already
+	// been exchanged is used again.  This suggests the token was stolen, so all
+	// tokens descending from the same login are revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Header is the JOSE header of a token
+type Header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Audience is the `aud` claim, which may be encoded as a single string or an
+// array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts both a single string and an array of strings
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(b, &multi); err != nil {
+		return err
+	}
+	*a = Audience(multi)
+	return nil
+}
+
+// Contains returns whether or not the audience includes the value
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Token use values, distinguishing access tokens from refresh tokens
+const (
+	UseAccess  = "access"
+	UseRefresh = "refresh"
+)
+
+// Claims are the registered claims of a token, plus the claims used by this
+// package for access and refresh tokens.
+type Claims struct {
+	ID        string   `json:"jti,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	// Permissions granted to the subject
+	Permissions []string `json:"perms,omitempty"`
+	// Use is either UseAccess or UseRefresh
+	Use string `json:"use,omitempty"`
+	// Family identifies all of the tokens descending from a single login,
+	// through refresh token rotation
+	Family string `json:"fam,omitempty"`
+}
+
+// Validate checks the time based claims against the current time, allowing for
+// the specified leeway in clock skew.
+func (c *Claims) Validate(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt != 0 && now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Unix() < c.NotBefore {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// ExpiresAtTime returns the expiry of the token as a `time.Time`
+func (c *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// SigningMethod signs and verifies tokens using a specific algorithm and key
+type SigningMethod interface {
+	// Alg returns the name of the algorithm for the `alg` header
+	Alg() string
+	// Sign returns the signature of the signing input
+	Sign(input []byte) ([]byte, error)
+	// Verify returns an error if the signature is not valid for the input
+	Verify(input, signature []byte) error
+}
+
+type hs256 struct {
+	secret []byte
+}
+
+// HS256 returns a SigningMethod using HMAC SHA-256 with a shared secret
+func HS256(secret []byte) SigningMethod {
+	return hs256{secret}
+}
+
+func (hs256) Alg() string {
+	return "HS256"
+}
+
+func (m hs256) Sign(input []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(input)
+	return mac.Sum(nil), nil
+}
+
+func (m hs256) Verify(input, signature []byte) error {
+	expected, _ := m.Sign(input)
+	if !hmac.Equal(expected, signature) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+type rs256 struct {
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+// RS256 returns a SigningMethod using RSA PKCS#1 v1.5 with SHA-256, which can both
+// sign and verify tokens.
+func RS256(key *rsa.PrivateKey) SigningMethod {
+	return rs256{key, &key.PublicKey}
+}
+
+// RS256PublicKey returns a SigningMethod using RSA PKCS#1 v1.5 with SHA-256,
+// which can only verify tokens.
+func RS256PublicKey(key *rsa.PublicKey) SigningMethod {
+	return rs256{nil, key}
+}
+
+func (rs256) Alg() string {
+	return "RS256"
+}
+
+func (m rs256) Sign(input []byte) ([]byte, error) {
+	if m.private == nil {
+		return nil, errors.New("RS256 signing requires a private key")
+	}
+	h := sha256.Sum256(input)
+	return rsa.SignPKCS1v15(rand.Reader, m.private, crypto.SHA256, h[:])
+}
+
+func (m rs256) Verify(input, signature []byte) error {
+	h := sha256.Sum256(input)
+	if err := rsa.VerifyPKCS1v15(m.public, crypto.SHA256, h[:], signature); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// KeyFunc returns the SigningMethod used to verify a token, for example by
+// looking up the key identified by the `kid` header.  A KeySet provides a KeyFunc
+// for keys fetched from a JWKS endpoint.
+type KeyFunc func(Header) (SigningMethod, error)
+
+// StaticKey returns a KeyFunc which always uses the same SigningMethod
+func StaticKey(method SigningMethod) KeyFunc {
+	return func(Header) (SigningMethod, error) {
+		return method, nil
+	}
+}
+
+var b64 = base64.RawURLEncoding
+
+// Encode signs the claims, which can be any value that `json.Marshal` can handle,
+// returning a compact serialized token.  The key id is optional.
+func Encode(method SigningMethod, kid string, claims interface{}) (string, error) {
+	header, err := json.Marshal(Header{Alg: method.Alg(), Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	sig, err := method.Sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64.EncodeToString(sig), nil
+}
+
+// Decode verifies the signature of a token using the key returned by the
+// KeyFunc, and unmarshals its payload into the claims.  Time based claims are
+// not checked.
+func Decode(token string, keyFn KeyFunc, claims interface{}) (Header, error) {
+	var header Header
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, ErrInvalidToken
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return header, ErrInvalidToken
+	}
+	method, err := keyFn(header)
+	if err != nil {
+		return header, err
+	}
+
+	// the algorithm is determined by the key, never the token, so that tokens
+	// can't choose a weaker algorithm than the one expected
+	if method.Alg() != header.Alg {
+		return header, ErrInvalidToken
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return header, ErrInvalidToken
+	}
+	if err := method.Verify([]byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return header, ErrInvalidToken
+	}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return header, ErrInvalidToken
+	}
+	return header, nil
+}
+
+func decodeSegment(seg string, target interface{}) error {
+	raw, err := b64.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(target)
+}
+
+// Verifier verifies access tokens, checking their signature, time based claims,
+// issuer, audience and revocation status.
+type Verifier struct {
+	// KeyFunc returns the key for verifying signatures
+	KeyFunc KeyFunc
+	// Issuer, if set, must match the `iss` claim
+	Issuer string
+	// Audience, if set, must be included in the `aud` claim
+	Audience string
+	// Leeway allowed for clock skew when checking time based claims
+	Leeway time.Duration
+	// Store, if set, is consulted for revoked tokens
+	Store Store
+}
+
+// Verify returns the claims of a valid access token
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	var claims Claims
+	if _, err := Decode(token, v.KeyFunc, &claims); err != nil {
+		return nil, err
+	}
+	if err := v.check(&claims); err != nil {
+		return nil, err
+	}
+	if claims.Use == UseRefresh {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+func (v *Verifier) check(claims *Claims) error {
+	if err := claims.Validate(time.Now(), v.Leeway); err != nil {
+		return err
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return ErrInvalidToken
+	}
+	if v.Audience != "" && !claims.Audience.Contains(v.Audience) {
+		return ErrInvalidToken
+	}
+	if v.Store != nil {
+		for _, id := range []string{claims.ID, claims.Family} {
+			if id == "" {
+				continue
+			}
+			revoked, err := v.Store.IsRevoked(id)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return ErrTokenRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// JWTAuthenticator receives the claims of a verified token, and is expected to
+// return an object that will be stored in the request context.  If an error is
+// returned, it's encouraged to return one of the errors defined in the auth
+// package.
+type JWTAuthenticator func(claims *Claims) (interface{}, error)
+
+// PermissionsAuthenticator is a JWTAuthenticator which trusts the token, returning
+// an `auth.BasicApiClient` with the subject and permissions of the token.
+func PermissionsAuthenticator(claims *Claims) (interface{}, error) {
+	return auth.NewBasicApiClient(claims.Subject, claims.Permissions), nil
+}
+
+// NewJWTAuthenticator creates a middleware that will detect an incoming token with
+// the specified `Authorization` scheme, verify it, call a user-defined function
+// with its claims, and store a returned object in the request context.  Tokens
+// which fail verification result in `auth.ErrAuthenticationRequired`.
+func NewJWTAuthenticator(keyname, contextKey string, failFn auth.ErrorHandler, verifier *Verifier, authFn JWTAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			req, err := checkToken(keyname, contextKey, r, verifier, authFn)
+			if err != nil {
+				failFn(rw, req, err)
+				return
+			}
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// NewJWTAuthenticatorMiddleware creates a negroni-style middleware that will detect
+// an incoming token with the specified `Authorization` scheme, verify it, call a
+// user-defined function with its claims, and store a returned object in the
+// request context.
+func NewJWTAuthenticatorMiddleware(keyname, contextKey string, failFn auth.ErrorHandler, verifier *Verifier, authFn JWTAuthenticator) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		req, err := checkToken(keyname, contextKey, r, verifier, authFn)
+		if err != nil {
+			failFn(rw, req, err)
+			return
+		}
+		next(rw, req)
+	}
+}
+
+func checkToken(keyname, contextKey string, r *http.Request, verifier *Verifier, authFn JWTAuthenticator) (*http.Request, error) {
+	authHeader := r.Header.Get("Authorization")
+
+	// no token sent, continue on
+	authHeaderParts := strings.Split(authHeader, " ")
+	if len(authHeaderParts) != 2 || authHeaderParts[0] != keyname {
+		return r, nil
+	}
+
+	claims, err := verifier.Verify(authHeaderParts[1])
+	switch err {
+	case nil:
+	case ErrInvalidToken, ErrTokenExpired, ErrTokenRevoked, ErrUnknownKey:
+		return r, auth.ErrAuthenticationRequired
+	default:
+		return r, err
+	}
+
+	obj, err := authFn(claims)
+	if err != nil {
+		return r, err
+	}
+	if obj == nil {
+		return r, errors.New("authenticator returned nil, should return error instead")
 	}
+	return r.WithContext(context.WithValue(r.Context(), contextKey, obj)), nil
 }
-*/
@@ -1 +1,265 @@
 package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func handler(rw http.ResponseWriter, r *http.Request) {
+	msg := "Hello world!"
+	if client, ok := r.Context().Value("ApiClient").(auth.Authenticator); ok {
+		msg = "Hello " + client.AuthenticationID()
+	}
+	rw.WriteHeader(200)
+	rw.Write([]byte(msg))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	for _, method := range []SigningMethod{HS256([]byte("secret")), RS256(rsaKey)} {
+		t.Run(method.Alg(), func(t *testing.T) {
+			token, err := Encode(method, "key-1", Claims{Subject: "user-1", Audience: Audience{"api"}})
+			require.Nil(t, err)
+
+			var claims Claims
+			header, err := Decode(token, StaticKey(method), &claims)
+			require.Nil(t, err)
+			require.Equal(t, method.Alg(), header.Alg)
+			require.Equal(t, "key-1", header.Kid)
+			require.Equal(t, "user-1", claims.Subject)
+			require.True(t, claims.Audience.Contains("api"))
+
+			// tampered payload fails
+			parts := strings.Split(token, ".")
+			forged, _ := Encode(method, "key-1", Claims{Subject: "admin"})
+			parts[1] = strings.Split(forged, ".")[1]
+			_, err = Decode(strings.Join(parts, "."), StaticKey(method), &claims)
+			require.Equal(t, ErrInvalidToken, err)
+		})
+	}
+
+	t.Run("algorithm must match key", func(t *testing.T) {
+		token, _ := Encode(HS256([]byte("secret")), "", Claims{Subject: "user-1"})
+		var claims Claims
+		_, err := Decode(token, StaticKey(RS256PublicKey(&rsaKey.PublicKey)), &claims)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("audience may be a string", func(t *testing.T) {
+		token, _ := Encode(HS256([]byte("secret")), "", map[string]interface{}{"aud": "api"})
+		var claims Claims
+		_, err := Decode(token, StaticKey(HS256([]byte("secret"))), &claims)
+		require.Nil(t, err)
+		require.Equal(t, Audience{"api"}, claims.Audience)
+	})
+}
+
+func TestKeySet(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys := []JSONWebKey{NewRSAJSONWebKey("key-1", &key1.PublicKey)}
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fetches++
+		out, _ := json.Marshal(JSONWebKeySet{keys})
+		rw.Write(out)
+	}))
+	defer ts.Close()
+	ks := NewKeySet(ts.URL)
+	verifier := &Verifier{KeyFunc: ks.KeyFunc}
+
+	token1, _ := Encode(RS256(key1), "key-1", Claims{Subject: "user-1"})
+	token2, _ := Encode(RS256(key2), "key-2", Claims{Subject: "user-1"})
+	_, err := verifier.Verify(token1)
+	require.Nil(t, err)
+	_, err = verifier.Verify(token2)
+	require.Equal(t, ErrUnknownKey, err)
+	require.Equal(t, 1, fetches)
+
+	// rotated keys are fetched when a token uses an unknown key, at most once
+	// per refresh interval
+	keys = append(keys, NewRSAJSONWebKey("key-2", &key2.PublicKey))
+	_, err = verifier.Verify(token2)
+	require.Equal(t, ErrUnknownKey, err)
+	ks.MinRefreshInterval = time.Nanosecond
+	_, err = verifier.Verify(token2)
+	require.Nil(t, err)
+	require.Equal(t, 2, fetches)
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Now()
+	c := Claims{ExpiresAt: now.Unix(), NotBefore: now.Add(-time.Minute).Unix()}
+	require.Equal(t, ErrTokenExpired, c.Validate(now, 0))
+	require.Nil(t, c.Validate(now, 5*time.Second))
+	c = Claims{NotBefore: now.Add(time.Minute).Unix()}
+	require.Equal(t, ErrTokenExpired, c.Validate(now, 0))
+}
+
+func TestKeySetOutage(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		mu.Unlock()
+		<-release
+		rw.WriteHeader(503)
+	}))
+	defer ts.Close()
+	ks := NewKeySet(ts.URL)
+	verifier := &Verifier{KeyFunc: ks.KeyFunc}
+	token, _ := Encode(RS256(key), "key-1", Claims{Subject: "user-1"})
+
+	// concurrent lookups wait on a single request
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Verify(token)
+			require.NotNil(t, err)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, 1, fetches)
+
+	// failed attempts aren't retried until the refresh interval has passed
+	_, err := verifier.Verify(token)
+	require.Contains(t, err.Error(), "fetching keys failed with status 503")
+	require.Equal(t, 1, fetches)
+}
+
+func TestIssuer(t *testing.T) {
+	issuer := NewIssuer(HS256([]byte("secret")), NewMemoryStore())
+	issuer.Issuer = "auth-service"
+	verifier := issuer.Verifier()
+
+	t.Run("access tokens", func(t *testing.T) {
+		token, err := issuer.IssueAccessToken("user-1", []string{"users.read"})
+		require.Nil(t, err)
+		claims, err := verifier.Verify(token)
+		require.Nil(t, err)
+		require.Equal(t, "user-1", claims.Subject)
+		require.Equal(t, "auth-service", claims.Issuer)
+		require.Equal(t, []string{"users.read"}, claims.Permissions)
+
+		require.Nil(t, issuer.Revoke(token))
+		_, err = verifier.Verify(token)
+		require.Equal(t, ErrTokenRevoked, err)
+	})
+
+	t.Run("expired tokens", func(t *testing.T) {
+		issuer.now = func() time.Time { return time.Now().Add(-time.Hour) }
+		defer func() { issuer.now = time.Now }()
+		token, _ := issuer.IssueAccessToken("user-1", nil)
+		_, err := verifier.Verify(token)
+		require.Equal(t, ErrTokenExpired, err)
+	})
+
+	t.Run("refresh token rotation", func(t *testing.T) {
+		pair, err := issuer.IssueTokens("user-1", []string{"users.read"})
+		require.Nil(t, err)
+		require.Equal(t, int64(900), pair.ExpiresIn)
+
+		// refresh tokens can't be used as access tokens
+		_, err = verifier.Verify(pair.RefreshToken)
+		require.Equal(t, ErrInvalidToken, err)
+
+		next, err := issuer.Refresh(pair.RefreshToken)
+		require.Nil(t, err)
+		claims, err := verifier.Verify(next.AccessToken)
+		require.Nil(t, err)
+		require.Equal(t, []string{"users.read"}, claims.Permissions)
+
+		// access tokens can't be used as refresh tokens
+		_, err = issuer.Refresh(next.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+
+		// reusing a refresh token revokes the whole family
+		_, err = issuer.Refresh(pair.RefreshToken)
+		require.Equal(t, ErrRefreshTokenReused, err)
+		_, err = verifier.Verify(next.AccessToken)
+		require.Equal(t, ErrTokenRevoked, err)
+		_, err = issuer.Refresh(next.RefreshToken)
+		require.Equal(t, ErrTokenRevoked, err)
+	})
+
+	t.Run("revoking refresh token revokes family", func(t *testing.T) {
+		pair, _ := issuer.IssueTokens("user-1", nil)
+		require.Nil(t, issuer.Revoke(pair.RefreshToken))
+		_, err := verifier.Verify(pair.AccessToken)
+		require.Equal(t, ErrTokenRevoked, err)
+		_, err = issuer.Refresh(pair.RefreshToken)
+		require.Equal(t, ErrTokenRevoked, err)
+	})
+}
+
+func TestIssuerLiteral(t *testing.T) {
+	// issuers can be configured without NewIssuer
+	issuer := &Issuer{Method: HS256([]byte("secret")), Store: NewMemoryStore(), AccessTTL: time.Minute, RefreshTTL: time.Hour}
+	pair, err := issuer.IssueTokens("user-1", []string{"users.read"})
+	require.Nil(t, err)
+	_, err = issuer.Refresh(pair.RefreshToken)
+	require.Nil(t, err)
+
+	// but must be complete
+	_, err = (&Issuer{Method: HS256([]byte("secret")), Store: NewMemoryStore()}).IssueAccessToken("user-1", nil)
+	require.NotNil(t, err)
+	_, err = (&Issuer{Method: HS256([]byte("secret")), AccessTTL: time.Minute, RefreshTTL: time.Hour}).IssueAccessToken("user-1", nil)
+	require.NotNil(t, err)
+	require.NotNil(t, (&Issuer{Store: NewMemoryStore()}).Revoke(pair.AccessToken))
+}
+
+func TestNewJWTAuthenticator(t *testing.T) {
+	issuer := NewIssuer(HS256([]byte("secret")), NewMemoryStore())
+	authenticate := NewJWTAuthenticator("Bearer", "ApiClient", auth.StandardErrorHandler, issuer.Verifier(), PermissionsAuthenticator)
+	authorize := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	h := authenticate(authorize(http.HandlerFunc(handler), "users.read"))
+
+	goodToken, _ := issuer.IssueAccessToken("user-1", []string{"users.read"})
+	badToken, _ := Encode(HS256([]byte("wrong")), "", Claims{Subject: "user-1", Permissions: []string{"users.read"}})
+	revokedToken, _ := issuer.IssueAccessToken("user-2", []string{"users.read"})
+	issuer.Revoke(revokedToken)
+	noPermsToken, _ := issuer.IssueAccessToken("user-3", nil)
+
+	tests := []struct {
+		header string
+		code   int
+		text   string
+	}{
+		{"", 401, "Authentication required"},
+		{"Key " + goodToken, 401, "Authentication required"},
+		{"Bearer " + goodToken, 200, "Hello user-1"},
+		{"Bearer " + badToken, 401, "Authentication required"},
+		{"Bearer " + revokedToken, 401, "Authentication required"},
+		{"Bearer " + noPermsToken, 403, "Access denied"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("Authorization", test.header)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		res := rw.Result()
+		out, _ := ioutil.ReadAll(res.Body)
+		require.Equal(t, test.code, res.StatusCode, test.header)
+		require.Equal(t, test.text, string(out))
+	}
+}
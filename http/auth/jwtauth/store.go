@@ -0,0 +1,74 @@
+package jwtauth
+
+import (
+	"sync"
+	"time"
+)
+
+// Store tracks revoked token ids and used refresh tokens.  Entries only need to
+// be kept until the specified time, after which the tokens they refer to have
+// expired anyway.  Implementations must be safe for concurrent use.
+type Store interface {
+	// Revoke adds a token id or family to the revocation list
+	Revoke(id string, until time.Time) error
+	// IsRevoked returns whether or not a token id or family has been revoked
+	IsRevoked(id string) (bool, error)
+	// MarkUsed records that a refresh token has been exchanged, returning false
+	// if it had already been used
+	MarkUsed(id string, until time.Time) (bool, error)
+}
+
+// MemoryStore is a Store which keeps entries in memory, suitable for tests and
+// single instance apps.
+type MemoryStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	used    map[string]time.Time
+}
+
+// NewMemoryStore returns a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{revoked: map[string]time.Time{}, used: map[string]time.Time{}}
+}
+
+// Revoke implements Store
+func (m *MemoryStore) Revoke(id string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(time.Now())
+	m.revoked[id] = until
+	return nil
+}
+
+// IsRevoked implements Store
+func (m *MemoryStore) IsRevoked(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	until, ok := m.revoked[id]
+	return ok && time.Now().Before(until), nil
+}
+
+// MarkUsed implements Store
+func (m *MemoryStore) MarkUsed(id string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(time.Now())
+	if _, ok := m.used[id]; ok {
+		return false, nil
+	}
+	m.used[id] = until
+	return true, nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for id, until := range m.revoked {
+		if now.After(until) {
+			delete(m.revoked, id)
+		}
+	}
+	for id, until := range m.used {
+		if now.After(until) {
+			delete(m.used, id)
+		}
+	}
+}
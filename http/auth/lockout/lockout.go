@@ -0,0 +1,153 @@
+// Package lockout tracks failed authentication attempts, so that authenticators
+// can refuse further attempts from a source once too many have failed.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy determines when, and for how long, a key is locked out after failed
+// attempts.
+type Policy struct {
+	// MaxFailures is the number of failures allowed before a key is locked out,
+	// defaults to 5
+	MaxFailures int
+	// Window is how long failures are remembered after the most recent one, or
+	// after the lockout it caused ends.  Defaults to 15 minutes.
+	Window time.Duration
+	// LockoutDuration is how long a key is locked out once MaxFailures is
+	// reached, defaults to 15 minutes.  Ignored if BaseDelay is set.
+	LockoutDuration time.Duration
+	// BaseDelay enables exponential backoff: the first lockout lasts BaseDelay,
+	// and each further failure doubles it, up to MaxDelay.
+	BaseDelay time.Duration
+	// MaxDelay caps exponential backoff, if set
+	MaxDelay time.Duration
+}
+
+func (p Policy) withDefaults() Policy {
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = 5
+	}
+	if p.Window <= 0 {
+		p.Window = 15 * time.Minute
+	}
+	if p.LockoutDuration <= 0 {
+		p.LockoutDuration = 15 * time.Minute
+	}
+	return p
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return p.LockoutDuration
+	}
+	d := p.BaseDelay
+	for i := p.MaxFailures; i < failures; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return d
+}
+
+// Tracker counts failed attempts per key.  Keys are arbitrary strings, such as a
+// client IP, or the hash of a presented credential.  It is safe for
+// concurrent use.
+type Tracker struct {
+	policy    Policy
+	now       func() time.Time
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// expired checks whether the failures have been forgotten.  The window starts
+// after the lockout ends, so that failures keep escalating backoff past it.
+func (e *entry) expired(now time.Time, window time.Duration) bool {
+	start := e.last
+	if e.lockedUntil.After(start) {
+		start = e.lockedUntil
+	}
+	return now.Sub(start) > window
+}
+
+// NewTracker returns a Tracker enforcing the specified Policy.  Unset fields of
+// the Policy use their defaults.
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		policy:    policy.withDefaults(),
+		now:       time.Now,
+		entries:   map[string]*entry{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Check returns how long until attempts are allowed for all of the keys.  A zero
+// duration means attempts are currently allowed.
+func (t *Tracker) Check(keys ...string) time.Duration {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var wait time.Duration
+	for _, k := range keys {
+		if e, ok := t.entries[k]; ok && e.lockedUntil.After(now) {
+			if d := e.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt for each of the keys, locking out any which
+// have reached the maximum number of failures.
+func (t *Tracker) Fail(keys ...string) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) > t.policy.Window {
+		t.sweep(now)
+	}
+
+	for _, k := range keys {
+		e, ok := t.entries[k]
+		if !ok || e.expired(now, t.policy.Window) {
+			e = &entry{}
+			t.entries[k] = e
+		}
+		e.failures++
+		e.last = now
+		if e.failures >= t.policy.MaxFailures {
+			e.lockedUntil = now.Add(t.policy.delay(e.failures))
+		}
+	}
+}
+
+// Reset forgets any failures recorded for the keys
+func (t *Tracker) Reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.entries, k)
+	}
+}
+
+func (t *Tracker) sweep(now time.Time) {
+	for k, e := range t.entries {
+		if e.expired(now, t.policy.Window) {
+			delete(t.entries, k)
+		}
+	}
+	t.lastSweep = now
+}
@@ -0,0 +1,94 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a tracker with a clock that can be moved forward
+func newTestTracker(p Policy) (*Tracker, func(time.Duration)) {
+	now := time.Now()
+	tr := NewTracker(p)
+	tr.now = func() time.Time { return now }
+	return tr, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestTrackerLockout(t *testing.T) {
+	tr, advance := newTestTracker(Policy{MaxFailures: 2, Window: time.Minute, LockoutDuration: 10 * time.Minute})
+
+	tr.Fail("ip:10.0.0.1")
+	require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.1"))
+	tr.Fail("ip:10.0.0.1")
+	require.Equal(t, 10*time.Minute, tr.Check("ip:10.0.0.1"))
+	require.Equal(t, 10*time.Minute, tr.Check("ip:10.0.0.2", "ip:10.0.0.1"))
+	require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.2"))
+
+	advance(10 * time.Minute)
+	require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.1"))
+
+	// failures outside the window are forgotten
+	tr.Fail("ip:10.0.0.2")
+	advance(2 * time.Minute)
+	tr.Fail("ip:10.0.0.2")
+	require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.2"))
+
+	// reset clears failures
+	tr.Reset("ip:10.0.0.2")
+	tr.Fail("ip:10.0.0.2")
+	require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.2"))
+}
+
+func TestTrackerBackoff(t *testing.T) {
+	tr, _ := newTestTracker(Policy{MaxFailures: 2, Window: time.Hour, BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+
+	tr.Fail("key:abc")
+	require.Equal(t, time.Duration(0), tr.Check("key:abc"))
+	tr.Fail("key:abc")
+	require.Equal(t, time.Second, tr.Check("key:abc"))
+	tr.Fail("key:abc")
+	require.Equal(t, 2*time.Second, tr.Check("key:abc"))
+	tr.Fail("key:abc")
+	require.Equal(t, 4*time.Second, tr.Check("key:abc"))
+	tr.Fail("key:abc")
+	require.Equal(t, 5*time.Second, tr.Check("key:abc"))
+}
+
+func TestTrackerBackoffPastWindow(t *testing.T) {
+	tr, advance := newTestTracker(Policy{MaxFailures: 2, Window: time.Minute, BaseDelay: 10 * time.Minute, MaxDelay: time.Hour})
+
+	tr.Fail("key:abc")
+	tr.Fail("key:abc")
+	require.Equal(t, 10*time.Minute, tr.Check("key:abc"))
+
+	// failing again once the lockout ends escalates, as the window starts then
+	advance(10*time.Minute + time.Second)
+	require.Equal(t, time.Duration(0), tr.Check("key:abc"))
+	tr.Fail("key:abc")
+	require.Equal(t, 20*time.Minute, tr.Check("key:abc"))
+
+	// and failures are forgotten a window after the lockout ends
+	advance(20*time.Minute + 2*time.Minute)
+	tr.Fail("key:abc")
+	require.Equal(t, time.Duration(0), tr.Check("key:abc"))
+}
+
+func TestTrackerDefaults(t *testing.T) {
+	tr, advance := newTestTracker(Policy{})
+
+	for i := 0; i < 4; i++ {
+		tr.Fail("ip:10.0.0.1")
+		require.Equal(t, time.Duration(0), tr.Check("ip:10.0.0.1"))
+		advance(time.Minute)
+	}
+	tr.Fail("ip:10.0.0.1")
+	require.Equal(t, 15*time.Minute, tr.Check("ip:10.0.0.1"))
+
+	// a zero window doesn't forget failures immediately
+	tr, advance = newTestTracker(Policy{MaxFailures: 2, LockoutDuration: time.Minute})
+	tr.Fail("ip:10.0.0.1")
+	advance(time.Second)
+	tr.Fail("ip:10.0.0.1")
+	require.Equal(t, time.Minute, tr.Check("ip:10.0.0.1"))
+}
@@ -0,0 +1,208 @@
+// Package mfa implements one-time passwords for use as a second authentication
+// factor: HOTP, described in RFC 4226, and TOTP, described in RFC 6238.  Codes are
+// compatible with common authenticator apps, which are enrolled by showing the
+// user an `otpauth://` URI, usually as a QR code.
+//
+// Routes requiring a recently verified second factor can be protected with
+// `auth.NewStepUpAuthorizer`.
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidCode is returned when a code doesn't match
+	ErrInvalidCode = errors.New("mfa: invalid code")
+	// ErrCodeReused is returned when a TOTP code matches, but one from the same or
+	// a later time step has already been used
+	ErrCodeReused = errors.New("mfa: code already used")
+)
+
+const (
+	// DefaultDigits is the number of digits in generated codes
+	DefaultDigits = 6
+	// DefaultPeriod is how long TOTP codes are valid for
+	DefaultPeriod = 30 * time.Second
+	// SecretSize is the size in bytes of generated secrets, as recommended by
+	// RFC 4226
+	SecretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret as unpadded base32, as expected by authenticator
+// apps
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// DecodeSecret decodes a base32 encoded secret, ignoring case, spaces and padding
+func DecodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Replace(s, " ", "", -1))
+	return encoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// HOTP generates and verifies counter based codes
+type HOTP struct {
+	Secret []byte
+	// Digits in each code, defaults to 6
+	Digits int
+	// LookAhead is how many counter values past the expected one are accepted, to
+	// resynchronize with clients which generated codes without using them
+	LookAhead int
+}
+
+// NewHOTP returns an HOTP for the secret with the default settings
+func NewHOTP(secret []byte) HOTP {
+	return HOTP{Secret: secret, Digits: DefaultDigits, LookAhead: 10}
+}
+
+// Generate returns the code for the counter value
+func (h HOTP) Generate(counter uint64) string {
+	return generate(h.Secret, counter, h.Digits)
+}
+
+// Verify checks the code against the counter value the app expects next, and
+// counter values up to LookAhead past it.  On success it returns the counter value
+// the app should expect next time, which must be stored so the code can't be
+// reused.
+func (h HOTP) Verify(code string, counter uint64) (uint64, error) {
+	for i := uint64(0); i <= uint64(h.LookAhead); i++ {
+		if equal(h.Generate(counter+i), code) {
+			return counter + i + 1, nil
+		}
+	}
+	return counter, ErrInvalidCode
+}
+
+// URI returns the `otpauth://` URI for enrolling the secret in an authenticator
+// app, starting at the counter value.
+func (h HOTP) URI(issuer, account string, counter uint64) string {
+	params := url.Values{}
+	params.Set("counter", strconv.FormatUint(counter, 10))
+	return uri("hotp", issuer, account, h.Secret, h.Digits, params)
+}
+
+// TOTP generates and verifies time based codes
+type TOTP struct {
+	Secret []byte
+	// Digits in each code, defaults to 6
+	Digits int
+	// Period is how long each code is valid for, in whole seconds.  Defaults to
+	// 30 seconds, which is also used if it's under a second.
+	Period time.Duration
+	// Skew is how many periods before or after the current one are accepted, to
+	// allow for clock drift and slow typing
+	Skew int
+}
+
+// NewTOTP returns a TOTP for the secret with the default settings, accepting
+// codes from one period either side of the current one.
+func NewTOTP(secret []byte) TOTP {
+	return TOTP{Secret: secret, Digits: DefaultDigits, Period: DefaultPeriod, Skew: 1}
+}
+
+// Step returns the time step for the time
+func (t TOTP) Step(at time.Time) int64 {
+	period := t.Period
+	if period < time.Second {
+		period = DefaultPeriod
+	}
+	return at.Unix() / int64(period/time.Second)
+}
+
+// Generate returns the code valid at the time
+func (t TOTP) Generate(at time.Time) string {
+	return generate(t.Secret, uint64(t.Step(at)), t.Digits)
+}
+
+// Verify checks the code against the time steps within Skew of the time.  To
+// prevent replays, the step of the last code the user successfully verified must
+// be passed, or zero if there isn't one.  Codes from that step or earlier fail
+// with ErrCodeReused.  On success it returns the step of the code, which must be
+// stored for the next verification.
+func (t TOTP) Verify(code string, at time.Time, lastStep int64) (int64, error) {
+	current := t.Step(at)
+	for i := -int64(t.Skew); i <= int64(t.Skew); i++ {
+		step := current + i
+		if step < 0 || !equal(generate(t.Secret, uint64(step), t.Digits), code) {
+			continue
+		}
+		if step <= lastStep {
+			return lastStep, ErrCodeReused
+		}
+		return step, nil
+	}
+	return lastStep, ErrInvalidCode
+}
+
+// URI returns the `otpauth://` URI for enrolling the secret in an authenticator
+// app.
+func (t TOTP) URI(issuer, account string) string {
+	params := url.Values{}
+	if t.Period >= time.Second {
+		params.Set("period", strconv.Itoa(int(t.Period/time.Second)))
+	}
+	return uri("totp", issuer, account, t.Secret, t.Digits, params)
+}
+
+// generate implements the HOTP algorithm from RFC 4226, section 5.3
+func generate(secret []byte, counter uint64, digits int) string {
+	if digits <= 0 {
+		digits = DefaultDigits
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+func equal(expected, code string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1
+}
+
+func uri(kind, issuer, account string, secret []byte, digits int, params url.Values) string {
+	if digits <= 0 {
+		digits = DefaultDigits
+	}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", strconv.Itoa(digits))
+	label := account
+	if issuer != "" {
+		params.Set("issuer", issuer)
+		label = issuer + ":" + account
+	}
+	u := url.URL{Scheme: "otpauth", Host: kind, Path: "/" + label, RawQuery: params.Encode()}
+	return u.String()
+}
@@ -0,0 +1,112 @@
+package mfa
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// test vectors from RFC 4226 appendix D and RFC 6238 appendix B
+var rfcSecret = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	h := NewHOTP(rfcSecret)
+	for counter, code := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		require.Equal(t, code, h.Generate(uint64(counter)))
+	}
+
+	next, err := h.Verify("755224", 0)
+	require.Nil(t, err)
+	require.Equal(t, uint64(1), next)
+
+	// codes within the look ahead window resynchronize the counter
+	next, err = h.Verify("520489", 1)
+	require.Nil(t, err)
+	require.Equal(t, uint64(10), next)
+
+	// used codes can't be reused
+	_, err = h.Verify("755224", next)
+	require.Equal(t, ErrInvalidCode, err)
+
+	h.LookAhead = 0
+	_, err = h.Verify("287082", 0)
+	require.Equal(t, ErrInvalidCode, err)
+}
+
+func TestTOTP(t *testing.T) {
+	totp := TOTP{Secret: rfcSecret, Digits: 8, Period: 30 * time.Second}
+	for unix, code := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		require.Equal(t, code, totp.Generate(time.Unix(unix, 0)))
+	}
+
+	// periods under a second use the default, rather than dividing by zero
+	at := time.Unix(1111111111, 0)
+	require.Equal(t, NewTOTP(rfcSecret).Step(at), TOTP{Secret: rfcSecret, Period: time.Millisecond}.Step(at))
+}
+
+func TestTOTPVerify(t *testing.T) {
+	totp := NewTOTP(rfcSecret)
+	now := time.Unix(1234567890, 0)
+	code := totp.Generate(now)
+
+	step, err := totp.Verify(code, now, 0)
+	require.Nil(t, err)
+	require.Equal(t, totp.Step(now), step)
+
+	// replays are rejected
+	_, err = totp.Verify(code, now, step)
+	require.Equal(t, ErrCodeReused, err)
+
+	// drift within the window is accepted
+	_, err = totp.Verify(totp.Generate(now.Add(-30*time.Second)), now, 0)
+	require.Nil(t, err)
+	_, err = totp.Verify(totp.Generate(now.Add(30*time.Second)), now, 0)
+	require.Nil(t, err)
+	_, err = totp.Verify(totp.Generate(now.Add(-90*time.Second)), now, 0)
+	require.Equal(t, ErrInvalidCode, err)
+
+	_, err = totp.Verify("000000", now, 0)
+	require.Equal(t, ErrInvalidCode, err)
+}
+
+func TestSecrets(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.Nil(t, err)
+	require.Len(t, secret, SecretSize)
+
+	encoded := EncodeSecret(rfcSecret)
+	require.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", encoded)
+	decoded, err := DecodeSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	require.Nil(t, err)
+	require.Equal(t, rfcSecret, decoded)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(NewTOTP(rfcSecret).URI("Example Co", "alice@example.com"))
+	require.Nil(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Example Co:alice@example.com", u.Path)
+	require.Equal(t, url.Values{
+		"secret":    {"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+		"issuer":    {"Example Co"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}, u.Query())
+
+	u, err = url.Parse(NewHOTP(rfcSecret).URI("", "alice", 5))
+	require.Nil(t, err)
+	require.Equal(t, "hotp", u.Host)
+	require.Equal(t, "/alice", u.Path)
+	require.Equal(t, "5", u.Query().Get("counter"))
+}
@@ -0,0 +1,296 @@
+// Package oauth provides support for apps acting as an OAuth2 resource server,
+// authenticating requests with bearer tokens issued by an authorization server.
+package oauth
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+)
+
+// IntrospectionResponse is the response of an RFC 7662 token introspection
+// endpoint
+type IntrospectionResponse struct {
+	Active    bool             `json:"active"`
+	Scope     string           `json:"scope,omitempty"`
+	ClientID  string           `json:"client_id,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	TokenType string           `json:"token_type,omitempty"`
+	ExpiresAt int64            `json:"exp,omitempty"`
+	IssuedAt  int64            `json:"iat,omitempty"`
+	NotBefore int64            `json:"nbf,omitempty"`
+	Subject   string           `json:"sub,omitempty"`
+	Audience  jwtauth.Audience `json:"aud,omitempty"`
+	Issuer    string           `json:"iss,omitempty"`
+	ID        string           `json:"jti,omitempty"`
+}
+
+// Scopes returns the space separated scopes of the token as a list
+func (r *IntrospectionResponse) Scopes() []string {
+	return strings.Fields(r.Scope)
+}
+
+func (r *IntrospectionResponse) copy() *IntrospectionResponse {
+	out := *r
+	out.Audience = append(jwtauth.Audience(nil), r.Audience...)
+	return &out
+}
+
+// TokenPrincipal describes the client authenticated by an introspected token.  It
+// implements the `auth.Authenticator`, `auth.Authorizer` and
+// `auth.PermissionLister` interfaces.
+type TokenPrincipal struct {
+	token *IntrospectionResponse
+	perms []string
+}
+
+// AuthenticationID returns the subject of the token, or the client id for tokens
+// without a subject, such as those issued via client credentials.
+func (p TokenPrincipal) AuthenticationID() string {
+	if p.token.Subject != "" {
+		return p.token.Subject
+	}
+	return p.token.ClientID
+}
+
+// HasPermission implements `auth.Authorizer`
+func (p TokenPrincipal) HasPermission(perm string) (bool, error) {
+	for _, granted := range p.perms {
+		if granted == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Permissions implements `auth.PermissionLister`
+func (p TokenPrincipal) Permissions() []string {
+	return p.perms
+}
+
+// Token returns the introspection response the principal was created from
+func (p TokenPrincipal) Token() *IntrospectionResponse {
+	return p.token
+}
+
+// Introspector authenticates opaque bearer tokens by calling an RFC 7662 token
+// introspection endpoint.  Active results are cached until the token expires.
+// It is safe for concurrent use.
+type Introspector struct {
+	// Endpoint is the URL of the introspection endpoint
+	Endpoint string
+	// ClientID and ClientSecret authenticate the resource server to the
+	// introspection endpoint
+	ClientID     string
+	ClientSecret string
+	// Client is used for introspection requests, defaults to
+	// `http.DefaultClient`
+	Client *http.Client
+	// Timeout bounds each introspection request, defaults to 10 seconds
+	Timeout time.Duration
+	// ScopePermissions maps token scopes to permissions.  If not set, each
+	// scope is granted as a permission of the same name.
+	ScopePermissions map[string][]string
+	// MaxCacheTTL, if set, limits how long active results are cached.  Tokens
+	// without an expiry are only cached if it is set.
+	MaxCacheTTL time.Duration
+	// MaxCacheEntries bounds the number of cached results, evicting the least
+	// recently used once reached.  Defaults to 10000.
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*list.Element
+	lru   *list.List
+}
+
+const (
+	// defaultMaxCacheEntries bounds the cache if MaxCacheEntries isn't set
+	defaultMaxCacheEntries = 10000
+	// defaultIntrospectionTimeout bounds introspection requests if Timeout isn't
+	// set
+	defaultIntrospectionTimeout = 10 * time.Second
+)
+
+type cachedToken struct {
+	key     [sha256.Size]byte
+	res     *IntrospectionResponse
+	expires time.Time
+}
+
+// NewIntrospector returns an Introspector for the endpoint, authenticating with
+// the client credentials.
+func NewIntrospector(endpoint, clientID, clientSecret string) *Introspector {
+	return &Introspector{Endpoint: endpoint, ClientID: clientID, ClientSecret: clientSecret}
+}
+
+// Authenticate introspects the token, returning a TokenPrincipal if it is active,
+// and `auth.ErrAuthenticationRequired` if not.  It can be used as an
+// `apikeyauth.APIKeyAuthenticator`.
+func (i *Introspector) Authenticate(token string) (interface{}, error) {
+	res, err := i.Introspect(token)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Active {
+		return nil, auth.ErrAuthenticationRequired
+	}
+	return TokenPrincipal{res, i.permissions(res.Scopes())}, nil
+}
+
+// Introspect returns the introspection response for the token, from the cache
+// if available.  The response is a copy, which the caller may modify.
+func (i *Introspector) Introspect(token string) (*IntrospectionResponse, error) {
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	i.mu.Lock()
+	if el, ok := i.cache[key]; ok {
+		cached := el.Value.(*cachedToken)
+		if now.Before(cached.expires) {
+			i.lru.MoveToFront(el)
+			i.mu.Unlock()
+			return cached.res.copy(), nil
+		}
+		i.remove(el)
+	}
+	i.mu.Unlock()
+
+	res, err := i.request(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens may have expired, or not be valid yet, even if the server hasn't
+	// noticed
+	if res.Active && res.ExpiresAt != 0 && now.Unix() >= res.ExpiresAt {
+		res.Active = false
+	}
+	if res.Active && res.NotBefore != 0 && now.Unix() < res.NotBefore {
+		res.Active = false
+	}
+	if expires, ok := i.cacheExpiry(res, now); ok {
+		i.mu.Lock()
+		i.store(&cachedToken{key, res.copy(), expires})
+		i.mu.Unlock()
+	}
+	return res, nil
+}
+
+func (i *Introspector) cacheExpiry(res *IntrospectionResponse, now time.Time) (time.Time, bool) {
+	if !res.Active {
+		return time.Time{}, false
+	}
+	var expires time.Time
+	if res.ExpiresAt != 0 {
+		expires = time.Unix(res.ExpiresAt, 0)
+	}
+	if i.MaxCacheTTL > 0 {
+		if max := now.Add(i.MaxCacheTTL); expires.IsZero() || max.Before(expires) {
+			expires = max
+		}
+	}
+	return expires, !expires.IsZero()
+}
+
+func (i *Introspector) store(cached *cachedToken) {
+	if i.cache == nil {
+		i.cache = map[[sha256.Size]byte]*list.Element{}
+		i.lru = list.New()
+	}
+	if el, ok := i.cache[cached.key]; ok {
+		i.remove(el)
+	}
+	i.cache[cached.key] = i.lru.PushFront(cached)
+
+	max := i.MaxCacheEntries
+	if max <= 0 {
+		max = defaultMaxCacheEntries
+	}
+	for i.lru.Len() > max {
+		i.remove(i.lru.Back())
+	}
+}
+
+func (i *Introspector) remove(el *list.Element) {
+	i.lru.Remove(el)
+	delete(i.cache, el.Value.(*cachedToken).key)
+}
+
+func (i *Introspector) request(token string) (*IntrospectionResponse, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", i.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(i.ClientID), url.QueryEscape(i.ClientSecret))
+
+	timeout := i.Timeout
+	if timeout == 0 {
+		timeout = defaultIntrospectionTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("introspection request failed with status %d: %s", res.StatusCode, body)
+	}
+
+	var out IntrospectionResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (i *Introspector) permissions(scopes []string) []string {
+	if i.ScopePermissions == nil {
+		return scopes
+	}
+	var perms []string
+	for _, scope := range scopes {
+		perms = append(perms, i.ScopePermissions[scope]...)
+	}
+	return perms
+}
+
+// NewIntrospectionAuthenticator creates a middleware that will detect an incoming
+// Bearer token, introspect it, and store a TokenPrincipal in the request context
+// at the specified key.
+func NewIntrospectionAuthenticator(contextKey string, failFn auth.ErrorHandler, introspector *Introspector) func(http.Handler) http.Handler {
+	return apikeyauth.NewAPIKeyAuthenticator("Bearer", contextKey, failFn, introspector.Authenticate)
+}
+
+// NewIntrospectionAuthenticatorMiddleware creates a negroni-style middleware that
+// will detect an incoming Bearer token, introspect it, and store a TokenPrincipal
+// in the request context at the specified key.
+func NewIntrospectionAuthenticatorMiddleware(contextKey string, failFn auth.ErrorHandler, introspector *Introspector) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return apikeyauth.NewAPIKeyAuthenticatorMiddleware("Bearer", contextKey, failFn, introspector.Authenticate)
+}
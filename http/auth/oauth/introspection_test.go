@@ -0,0 +1,167 @@
+package oauth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+	"github.com/stretchr/testify/require"
+)
+
+// introspectionServer returns a test introspection endpoint which knows about a
+// fixed set of tokens, and counts how many times each was introspected.
+func introspectionServer() (*httptest.Server, map[string]int) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	exp := time.Now().Add(time.Hour).Unix()
+	tokens := map[string]map[string]interface{}{
+		"user-token":    {"active": true, "sub": "user-1", "scope": "users.read users.write", "exp": exp},
+		"client-token":  {"active": true, "client_id": "client-1", "scope": "users.read", "exp": exp},
+		"expired-token": {"active": true, "sub": "user-1", "scope": "users.read", "exp": time.Now().Add(-time.Minute).Unix()},
+		"future-token":  {"active": true, "sub": "user-1", "scope": "users.read", "exp": exp, "nbf": time.Now().Add(time.Minute).Unix()},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "resource-server" || secret != "secret" {
+			jsonio.Respond(rw, 401, map[string]string{"error": "invalid_client"})
+			return
+		}
+		token := r.PostFormValue("token")
+		mu.Lock()
+		calls[token]++
+		mu.Unlock()
+		if res, ok := tokens[token]; ok {
+			jsonio.Respond(rw, 200, res)
+			return
+		}
+		jsonio.Respond(rw, 200, map[string]bool{"active": false})
+	}))
+	return ts, calls
+}
+
+func TestIntrospector(t *testing.T) {
+	ts, calls := introspectionServer()
+	defer ts.Close()
+	introspector := NewIntrospector(ts.URL, "resource-server", "secret")
+
+	obj, err := introspector.Authenticate("user-token")
+	require.Nil(t, err)
+	principal := obj.(TokenPrincipal)
+	require.Equal(t, "user-1", principal.AuthenticationID())
+	allowed, _ := principal.HasPermission("users.write")
+	require.True(t, allowed)
+	allowed, _ = principal.HasPermission("users.delete")
+	require.False(t, allowed)
+
+	// active results are cached
+	introspector.Authenticate("user-token")
+	require.Equal(t, 1, calls["user-token"])
+
+	obj, err = introspector.Authenticate("client-token")
+	require.Nil(t, err)
+	require.Equal(t, "client-1", obj.(TokenPrincipal).AuthenticationID())
+
+	// inactive, expired and not yet valid tokens are rejected, and not cached
+	for _, token := range []string{"unknown-token", "expired-token", "future-token"} {
+		_, err = introspector.Authenticate(token)
+		require.Equal(t, auth.ErrAuthenticationRequired, err)
+		introspector.Authenticate(token)
+		require.Equal(t, 2, calls[token])
+	}
+
+	// cached results can't be modified by callers
+	res, err := introspector.Introspect("user-token")
+	require.Nil(t, err)
+	res.Active = false
+	res, err = introspector.Introspect("user-token")
+	require.Nil(t, err)
+	require.True(t, res.Active)
+	require.Equal(t, 1, calls["user-token"])
+
+	// server errors are passed on
+	_, err = NewIntrospector(ts.URL, "resource-server", "wrong").Authenticate("user-token")
+	require.Contains(t, err.Error(), "introspection request failed with status 401")
+}
+
+func TestIntrospectorTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	// a hung introspection endpoint fails the request rather than blocking it
+	introspector := NewIntrospector(ts.URL, "resource-server", "secret")
+	introspector.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	_, err := introspector.Authenticate("user-token")
+	require.NotNil(t, err)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestIntrospectorCacheBound(t *testing.T) {
+	ts, calls := introspectionServer()
+	defer ts.Close()
+	introspector := NewIntrospector(ts.URL, "resource-server", "secret")
+	introspector.MaxCacheEntries = 1
+
+	// the least recently used result is evicted once the cache is full
+	introspector.Authenticate("user-token")
+	introspector.Authenticate("client-token")
+	introspector.Authenticate("client-token")
+	introspector.Authenticate("user-token")
+	require.Equal(t, 2, calls["user-token"])
+	require.Equal(t, 1, calls["client-token"])
+	require.Equal(t, 1, introspector.lru.Len())
+}
+
+func TestIntrospectorScopePermissions(t *testing.T) {
+	ts, _ := introspectionServer()
+	defer ts.Close()
+	introspector := NewIntrospector(ts.URL, "resource-server", "secret")
+	introspector.ScopePermissions = map[string][]string{"users.write": {"users.create", "users.update"}}
+
+	obj, err := introspector.Authenticate("user-token")
+	require.Nil(t, err)
+	principal := obj.(TokenPrincipal)
+	for perm, expected := range map[string]bool{"users.create": true, "users.update": true, "users.read": false, "users.write": false} {
+		allowed, _ := principal.HasPermission(perm)
+		require.Equal(t, expected, allowed, perm)
+	}
+}
+
+func TestNewIntrospectionAuthenticator(t *testing.T) {
+	ts, _ := introspectionServer()
+	defer ts.Close()
+	authenticate := NewIntrospectionAuthenticator("ApiClient", auth.StandardErrorHandler, NewIntrospector(ts.URL, "resource-server", "secret"))
+	authorize := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	h := authenticate(authorize(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("Hello " + r.Context().Value("ApiClient").(auth.Authenticator).AuthenticationID()))
+	}), "users.write"))
+
+	tests := []struct {
+		token string
+		code  int
+		text  string
+	}{
+		{"user-token", 200, "Hello user-1"},
+		{"client-token", 403, "Access denied"},
+		{"unknown-token", 401, "Authentication required"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		res := rw.Result()
+		out, _ := ioutil.ReadAll(res.Body)
+		require.Equal(t, test.code, res.StatusCode)
+		require.Equal(t, test.text, string(out))
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Observer is notified of the outcomes of authenticators and authorizers, so apps
+// can react to them, for example by updating last used timestamps or emitting
+// metrics, without wrapping every middleware.  Callbacks are made synchronously,
+// so should return quickly.
+//
+// OnAuthorized and OnDenied are called by every authorizer a request passes
+// through, so may be called more than once per request when authorizers are
+// nested.  The principal passed to OnDenied may be nil.
+type Observer interface {
+	OnAuthenticated(r *http.Request, principal interface{})
+	OnAuthenticationFailed(r *http.Request, err error)
+	OnAuthorized(r *http.Request, principal interface{})
+	OnDenied(r *http.Request, principal interface{}, err error)
+}
+
+// ObserverFuncs implements Observer with optional functions, for apps only
+// interested in some outcomes.
+type ObserverFuncs struct {
+	Authenticated        func(r *http.Request, principal interface{})
+	AuthenticationFailed func(r *http.Request, err error)
+	Authorized           func(r *http.Request, principal interface{})
+	Denied               func(r *http.Request, principal interface{}, err error)
+}
+
+func (o ObserverFuncs) OnAuthenticated(r *http.Request, principal interface{}) {
+	if o.Authenticated != nil {
+		o.Authenticated(r, principal)
+	}
+}
+
+func (o ObserverFuncs) OnAuthenticationFailed(r *http.Request, err error) {
+	if o.AuthenticationFailed != nil {
+		o.AuthenticationFailed(r, err)
+	}
+}
+
+func (o ObserverFuncs) OnAuthorized(r *http.Request, principal interface{}) {
+	if o.Authorized != nil {
+		o.Authorized(r, principal)
+	}
+}
+
+func (o ObserverFuncs) OnDenied(r *http.Request, principal interface{}, err error) {
+	if o.Denied != nil {
+		o.Denied(r, principal, err)
+	}
+}
+
+// MultiObserver returns an Observer notifying each of the observers in order
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) OnAuthenticated(r *http.Request, principal interface{}) {
+	for _, o := range m {
+		o.OnAuthenticated(r, principal)
+	}
+}
+
+func (m multiObserver) OnAuthenticationFailed(r *http.Request, err error) {
+	for _, o := range m {
+		o.OnAuthenticationFailed(r, err)
+	}
+}
+
+func (m multiObserver) OnAuthorized(r *http.Request, principal interface{}) {
+	for _, o := range m {
+		o.OnAuthorized(r, principal)
+	}
+}
+
+func (m multiObserver) OnDenied(r *http.Request, principal interface{}, err error) {
+	for _, o := range m {
+		o.OnDenied(r, principal, err)
+	}
+}
+
+type observerKey struct{}
+
+// NewObserverInjector returns a middleware which sets the observer notified by
+// the authenticators and authorizers handling the request.  It must run before
+// them, so is generally the first auth middleware.
+func NewObserverInjector(observer Observer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(rw, WithObserver(r, observer))
+		})
+	}
+}
+
+// NewObserverInjectorMiddleware returns a negroni-style middleware which sets the
+// observer notified by the authenticators and authorizers handling the request.
+func NewObserverInjectorMiddleware(observer Observer) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		next(rw, WithObserver(r, observer))
+	}
+}
+
+// WithObserver returns a copy of the request carrying the observer
+func WithObserver(r *http.Request, observer Observer) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), observerKey{}, observer))
+}
+
+// ObserverFromRequest returns the observer set on the request, or nil
+func ObserverFromRequest(r *http.Request) Observer {
+	o, _ := r.Context().Value(observerKey{}).(Observer)
+	return o
+}
+
+// NotifyAuthenticated notifies the request's observer, if any, that an
+// authenticator stored the principal in the request context.  It's intended for
+// authenticator middlewares.
+func NotifyAuthenticated(r *http.Request, principal interface{}) {
+	if o := ObserverFromRequest(r); o != nil {
+		o.OnAuthenticated(r, principal)
+	}
+}
+
+// NotifyAuthenticationFailed notifies the request's observer, if any, that an
+// authenticator rejected the credentials sent with the request.  It's intended for
+// authenticator middlewares.
+func NotifyAuthenticationFailed(r *http.Request, err error) {
+	if o := ObserverFromRequest(r); o != nil {
+		o.OnAuthenticationFailed(r, err)
+	}
+}
+
+// NotifyAuthorized notifies the request's observer, if any, that an authorizer
+// allowed the principal to make the request.  It's intended for authorizers
+// defined outside of this package.
+func NotifyAuthorized(r *http.Request, principal interface{}) {
+	if o := ObserverFromRequest(r); o != nil {
+		o.OnAuthorized(r, principal)
+	}
+}
+
+// NotifyDenied notifies the request's observer, if any, that an authorizer refused
+// the request.  It's intended for authorizers defined outside of this package.
+func NotifyDenied(r *http.Request, principal interface{}, err error) {
+	if o := ObserverFromRequest(r); o != nil {
+		o.OnDenied(r, principal, err)
+	}
+}
+
+// notifyAuthorization notifies the request's observer, if any, of the outcome of
+// an authorizer checking the principal at keyname
+func notifyAuthorization(r *http.Request, keyname string, err error) {
+	principal := r.Context().Value(keyname)
+	if err != nil {
+		NotifyDenied(r, principal, err)
+		return
+	}
+	NotifyAuthorized(r, principal)
+}
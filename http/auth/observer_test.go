@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) OnAuthenticated(r *http.Request, principal interface{}) {
+	o.events = append(o.events, "authenticated")
+}
+
+func (o *recordingObserver) OnAuthenticationFailed(r *http.Request, err error) {
+	o.events = append(o.events, "authentication failed: "+err.Error())
+}
+
+func (o *recordingObserver) OnAuthorized(r *http.Request, principal interface{}) {
+	o.events = append(o.events, "authorized: "+principal.(Authenticator).AuthenticationID())
+}
+
+func (o *recordingObserver) OnDenied(r *http.Request, principal interface{}, err error) {
+	o.events = append(o.events, "denied: "+err.Error())
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	var failures []error
+	observe := NewObserverInjector(MultiObserver(observer, ObserverFuncs{
+		Denied: func(r *http.Request, principal interface{}, err error) {
+			failures = append(failures, err)
+		},
+	}))
+	authClient := NewClientAuthorizer("ApiClient", StandardErrorHandler)
+	authPerms := NewPermissionsAuthorizer("ApiClient", StandardErrorHandler)
+	h := observe(authClient(authPerms(http.HandlerFunc(handler), "users.write")))
+
+	call := func(client interface{}) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if client != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Result().StatusCode
+	}
+
+	require.Equal(t, 200, call(NewBasicApiClient("writer", []string{"users.write"})))
+	require.Equal(t, 403, call(NewBasicApiClient("reader", []string{"users.read"})))
+	require.Equal(t, 401, call(nil))
+	require.Equal(t, []string{
+		"authorized: writer",
+		"authorized: writer",
+		"authorized: reader",
+		"denied: permission denied: users.write",
+		"denied: authentication required",
+	}, observer.events)
+	require.Equal(t, []error{ErrPermissionDenied{"users.write"}, ErrAuthenticationRequired}, failures)
+}
+
+func TestNoObserver(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	require.Nil(t, ObserverFromRequest(r))
+	// notifying without an observer is a no-op
+	NotifyAuthenticated(r, nil)
+	NotifyAuthenticationFailed(r, ErrAuthenticationRequired)
+}
@@ -0,0 +1,252 @@
+// Package oidc provides OpenID Connect support: provider discovery, ID token
+// validation, and fetching userinfo.  Standard claims are mapped into a Principal
+// which can be used with the authorizers in the auth package.
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+)
+
+var (
+	// ErrNonceMismatch is returned when an ID token doesn't contain the nonce
+	// sent in the authentication request
+	ErrNonceMismatch = errors.New("id token nonce mismatch")
+	// ErrAccessTokenHashMismatch is returned when the `at_hash` claim of an ID
+	// token doesn't match the access token issued with it
+	ErrAccessTokenHashMismatch = errors.New("id token access token hash mismatch")
+	// ErrSubjectMismatch is returned when a userinfo response is for a different
+	// subject than expected
+	ErrSubjectMismatch = errors.New("userinfo subject mismatch")
+)
+
+// ProviderMetadata is the OpenID Provider configuration, as served from its
+// `.well-known/openid-configuration` document
+type ProviderMetadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint,omitempty"`
+	ScopesSupported       []string `json:"scopes_supported,omitempty"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// StandardClaims are the standard claims describing a user, found in both ID
+// tokens and userinfo responses
+type StandardClaims struct {
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// IDToken holds the claims of a validated ID token
+type IDToken struct {
+	jwtauth.Claims
+	StandardClaims
+	Nonce           string `json:"nonce,omitempty"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	AccessTokenHash string `json:"at_hash,omitempty"`
+	AuthTime        int64  `json:"auth_time,omitempty"`
+}
+
+// UserInfo is the response of the userinfo endpoint
+type UserInfo struct {
+	Subject string `json:"sub"`
+	StandardClaims
+}
+
+// Principal describes a user authenticated via OpenID Connect.  It implements
+// the `auth.Authenticator`, `auth.Authorizer` and `auth.PermissionLister`
+// interfaces.
+type Principal struct {
+	Subject string
+	Claims  StandardClaims
+	perms   []string
+}
+
+// AuthenticationID returns the subject identifier of the user
+func (p Principal) AuthenticationID() string {
+	return p.Subject
+}
+
+// HasPermission implements `auth.Authorizer`
+func (p Principal) HasPermission(perm string) (bool, error) {
+	for _, granted := range p.perms {
+		if granted == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Permissions implements `auth.PermissionLister`
+func (p Principal) Permissions() []string {
+	return p.perms
+}
+
+// Provider validates ID tokens issued by an OpenID Provider for a client.
+type Provider struct {
+	// Metadata discovered from the provider
+	Metadata ProviderMetadata
+	// Keys used to verify ID token signatures
+	Keys *jwtauth.KeySet
+	// ClientID of the app, which ID tokens must be issued to
+	ClientID string
+	// GroupPermissions maps the `groups` claim to permissions granted to a
+	// Principal
+	GroupPermissions map[string][]string
+	// Leeway allowed for clock skew when checking time based claims
+	Leeway time.Duration
+	// Client is used for requests to the provider
+	Client *http.Client
+}
+
+// NewProvider discovers the configuration of the OpenID Provider at the issuer
+// URL, for validating tokens issued to the client.  If the http client is nil,
+// `http.DefaultClient` is used.
+func NewProvider(issuer, clientID string, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var meta ProviderMetadata
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(client, wellKnown, "", &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != issuer {
+		return nil, fmt.Errorf("provider issuer %q does not match %q", meta.Issuer, issuer)
+	}
+
+	keys := jwtauth.NewKeySet(meta.JWKSURI)
+	keys.Client = client
+	return &Provider{Metadata: meta, Keys: keys, ClientID: clientID, Client: client}, nil
+}
+
+// VerifyIDToken validates an ID token, returning its claims.  The nonce, if not
+// empty, must match the `nonce` claim.  The access token, if not empty, must
+// match the `at_hash` claim when present.
+func (p *Provider) VerifyIDToken(token, nonce, accessToken string) (*IDToken, error) {
+	var id IDToken
+	header, err := jwtauth.Decode(token, p.Keys.KeyFunc, &id)
+	if err != nil {
+		return nil, err
+	}
+	if err := id.Validate(time.Now(), p.Leeway); err != nil {
+		return nil, err
+	}
+	if id.Issuer != p.Metadata.Issuer || id.Subject == "" || id.ExpiresAt == 0 {
+		return nil, jwtauth.ErrInvalidToken
+	}
+
+	// the client must be an audience, and if there are others, the authorized
+	// party must be the client
+	if !id.Audience.Contains(p.ClientID) {
+		return nil, jwtauth.ErrInvalidToken
+	}
+	if (len(id.Audience) > 1 || id.AuthorizedParty != "") && id.AuthorizedParty != p.ClientID {
+		return nil, jwtauth.ErrInvalidToken
+	}
+
+	if nonce != "" && id.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	if accessToken != "" && id.AccessTokenHash != "" {
+		if header.Alg != "RS256" || id.AccessTokenHash != AccessTokenHash(accessToken) {
+			return nil, ErrAccessTokenHashMismatch
+		}
+	}
+	return &id, nil
+}
+
+// AccessTokenHash returns the `at_hash` value for an access token issued with an
+// RS256 signed ID token
+func AccessTokenHash(accessToken string) string {
+	h := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(h[:len(h)/2])
+}
+
+// UserInfo fetches the claims of the user the access token was issued to.  The
+// subject, if not empty, must match the subject of the response.
+func (p *Provider) UserInfo(accessToken, subject string) (*UserInfo, error) {
+	if p.Metadata.UserinfoEndpoint == "" {
+		return nil, errors.New("provider has no userinfo endpoint")
+	}
+	var info UserInfo
+	if err := getJSON(p.client(), p.Metadata.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, err
+	}
+	if subject != "" && info.Subject != subject {
+		return nil, ErrSubjectMismatch
+	}
+	return &info, nil
+}
+
+// Principal returns a Principal for the subject, granting permissions mapped
+// from the groups in its claims
+func (p *Provider) Principal(subject string, claims StandardClaims) Principal {
+	var perms []string
+	for _, group := range claims.Groups {
+		perms = append(perms, p.GroupPermissions[group]...)
+	}
+	return Principal{subject, claims, perms}
+}
+
+// Authenticate validates an ID token presented as a bearer token, returning a
+// Principal.  Invalid tokens result in `auth.ErrAuthenticationRequired`.  It can
+// be used as an `apikeyauth.APIKeyAuthenticator`.
+func (p *Provider) Authenticate(token string) (interface{}, error) {
+	id, err := p.VerifyIDToken(token, "", "")
+	switch err {
+	case nil:
+	case jwtauth.ErrInvalidToken, jwtauth.ErrTokenExpired, jwtauth.ErrUnknownKey:
+		return nil, auth.ErrAuthenticationRequired
+	default:
+		return nil, err
+	}
+	return p.Principal(id.Subject, id.StandardClaims), nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func getJSON(client *http.Client, url, bearer string, target interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return fmt.Errorf("request to %s failed with status %d", url, res.StatusCode)
+	}
+	return json.Unmarshal(body, target)
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider serves discovery, keys and userinfo for a single signing key
+type testProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	p := &testProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		jsonio.Respond(rw, 200, ProviderMetadata{
+			Issuer:           p.URL,
+			JWKSURI:          p.URL + "/keys",
+			UserinfoEndpoint: p.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/keys", func(rw http.ResponseWriter, r *http.Request) {
+		jsonio.Respond(rw, 200, jwtauth.JSONWebKeySet{Keys: []jwtauth.JSONWebKey{jwtauth.NewRSAJSONWebKey("key-1", &key.PublicKey)}})
+	})
+	mux.HandleFunc("/userinfo", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-access-token" {
+			rw.WriteHeader(401)
+			return
+		}
+		jsonio.Respond(rw, 200, map[string]interface{}{"sub": "user-1", "email": "user@example.com", "groups": []string{"admins"}})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testProvider) token(t *testing.T, claims map[string]interface{}) string {
+	base := map[string]interface{}{
+		"iss": p.URL,
+		"sub": "user-1",
+		"aud": "my-app",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		base[k] = v
+	}
+	token, err := jwtauth.Encode(jwtauth.RS256(p.key), "key-1", base)
+	require.Nil(t, err)
+	return token
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	provider, err := NewProvider(p.URL, "my-app", nil)
+	require.Nil(t, err)
+
+	t.Run("valid token", func(t *testing.T) {
+		token := p.token(t, map[string]interface{}{
+			"nonce":   "n-1",
+			"at_hash": AccessTokenHash("good-access-token"),
+			"email":   "user@example.com",
+			"groups":  []string{"admins", "staff"},
+		})
+		id, err := provider.VerifyIDToken(token, "n-1", "good-access-token")
+		require.Nil(t, err)
+		require.Equal(t, "user-1", id.Subject)
+		require.Equal(t, "user@example.com", id.Email)
+		require.Equal(t, []string{"admins", "staff"}, id.Groups)
+	})
+
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		nonce       string
+		accessToken string
+		err         error
+	}{
+		{"wrong issuer", map[string]interface{}{"iss": "https://evil.example.com"}, "", "", jwtauth.ErrInvalidToken},
+		{"wrong audience", map[string]interface{}{"aud": "other-app"}, "", "", jwtauth.ErrInvalidToken},
+		{"multiple audiences without azp", map[string]interface{}{"aud": []string{"my-app", "other-app"}}, "", "", jwtauth.ErrInvalidToken},
+		{"wrong azp", map[string]interface{}{"azp": "other-app"}, "", "", jwtauth.ErrInvalidToken},
+		{"missing expiry", map[string]interface{}{"exp": nil}, "", "", jwtauth.ErrInvalidToken},
+		{"expired", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}, "", "", jwtauth.ErrTokenExpired},
+		{"wrong nonce", map[string]interface{}{"nonce": "n-2"}, "n-1", "", ErrNonceMismatch},
+		{"wrong at_hash", map[string]interface{}{"at_hash": AccessTokenHash("other")}, "", "good-access-token", ErrAccessTokenHashMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(p.token(t, test.claims), test.nonce, test.accessToken)
+			require.Equal(t, test.err, err)
+		})
+	}
+
+	t.Run("multiple audiences with azp", func(t *testing.T) {
+		token := p.token(t, map[string]interface{}{"aud": []string{"my-app", "other-app"}, "azp": "my-app"})
+		_, err := provider.VerifyIDToken(token, "", "")
+		require.Nil(t, err)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		token, _ := jwtauth.Encode(jwtauth.RS256(p.key), "key-2", map[string]interface{}{"iss": p.URL})
+		_, err := provider.VerifyIDToken(token, "", "")
+		require.Equal(t, jwtauth.ErrUnknownKey, err)
+	})
+}
+
+func TestUserInfo(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	provider, err := NewProvider(p.URL, "my-app", nil)
+	require.Nil(t, err)
+
+	info, err := provider.UserInfo("good-access-token", "user-1")
+	require.Nil(t, err)
+	require.Equal(t, "user@example.com", info.Email)
+
+	_, err = provider.UserInfo("good-access-token", "user-2")
+	require.Equal(t, ErrSubjectMismatch, err)
+
+	_, err = provider.UserInfo("bad-access-token", "")
+	require.NotNil(t, err)
+}
+
+func TestPrincipal(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	provider, err := NewProvider(p.URL, "my-app", nil)
+	require.Nil(t, err)
+	provider.GroupPermissions = map[string][]string{
+		"admins": {"users.read", "users.write"},
+		"staff":  {"users.read"},
+	}
+
+	obj, err := provider.Authenticate(p.token(t, map[string]interface{}{"groups": []string{"staff"}}))
+	require.Nil(t, err)
+	require.Implements(t, (*auth.Authenticator)(nil), obj)
+	require.Implements(t, (*auth.Authorizer)(nil), obj)
+	require.Equal(t, "user-1", obj.(Principal).AuthenticationID())
+	allowed, _ := obj.(Principal).HasPermission("users.read")
+	require.True(t, allowed)
+	allowed, _ = obj.(Principal).HasPermission("users.write")
+	require.False(t, allowed)
+
+	_, err = provider.Authenticate(p.token(t, map[string]interface{}{"aud": "other-app"}))
+	require.Equal(t, auth.ErrAuthenticationRequired, err)
+}
+
+func TestNewProviderIssuerMismatch(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	_, err := NewProvider(p.URL+"/", "my-app", nil)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,305 @@
+// Package openapi generates OpenAPI 3 security schemes and per-operation security
+// requirements from the auth configuration of a service, so that specs don't drift
+// from how routes are actually protected.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth/authmux"
+)
+
+// ErrInvalidSpec is returned when merging into a document which isn't a JSON
+// object, or whose `paths` or `components` aren't objects.
+var ErrInvalidSpec = errors.New("openapi: invalid spec document")
+
+// Scheme is an OpenAPI security scheme object
+type Scheme struct {
+	Type             string `json:"type"`
+	Description      string `json:"description,omitempty"`
+	Name             string `json:"name,omitempty"`
+	In               string `json:"in,omitempty"`
+	Scheme           string `json:"scheme,omitempty"`
+	BearerFormat     string `json:"bearerFormat,omitempty"`
+	Flows            *Flows `json:"flows,omitempty"`
+	OpenIDConnectURL string `json:"openIdConnectUrl,omitempty"`
+}
+
+// Flows are the OAuth2 flows supported by an oauth2 scheme
+type Flows struct {
+	ClientCredentials *Flow `json:"clientCredentials,omitempty"`
+	AuthorizationCode *Flow `json:"authorizationCode,omitempty"`
+}
+
+// Flow is an OAuth2 flow.  Scopes are filled in from the permissions required by
+// routes when generating.
+type Flow struct {
+	AuthorizationURL string            `json:"authorizationUrl,omitempty"`
+	TokenURL         string            `json:"tokenUrl,omitempty"`
+	RefreshURL       string            `json:"refreshUrl,omitempty"`
+	Scopes           map[string]string `json:"scopes"`
+}
+
+// APIKey describes the schemes used by `apikeyauth.NewAPIKeyAuthenticator`, where
+// the key is sent in the Authorization header after the keyname, e.g.
+// `Authorization: ApiKey <key>`.
+func APIKey(keyname string) Scheme {
+	return Scheme{Type: "http", Scheme: keyname}
+}
+
+// Bearer describes bearer tokens in the Authorization header, such as the JWTs
+// accepted by `jwtauth.NewJWTAuthenticator`.  The format is optional.
+func Bearer(format string) Scheme {
+	return Scheme{Type: "http", Scheme: "bearer", BearerFormat: format}
+}
+
+// ClientCredentials describes an OAuth2 client credentials flow using the token url
+func ClientCredentials(tokenURL string) Scheme {
+	return Scheme{Type: "oauth2", Flows: &Flows{ClientCredentials: &Flow{TokenURL: tokenURL}}}
+}
+
+// OpenIDConnect describes an OpenID Connect provider by its discovery url
+func OpenIDConnect(discoveryURL string) Scheme {
+	return Scheme{Type: "openIdConnect", OpenIDConnectURL: discoveryURL}
+}
+
+// hasScopes reports whether security requirements for the scheme may list
+// scopes.  In OpenAPI 3.0 only oauth2 and openIdConnect schemes may.
+func (s Scheme) hasScopes() bool {
+	return s.Type == "oauth2" || s.Type == "openIdConnect"
+}
+
+// PermissionsExtension is the operation extension listing the permissions a route
+// requires, as schemes other than oauth2 and openIdConnect can't list scopes.
+const PermissionsExtension = "x-permissions"
+
+// Generator generates the security sections of a spec
+type Generator struct {
+	// Schemes accepted by the service, by name.  Every protected route accepts
+	// any of them.
+	Schemes map[string]Scheme
+	// ScopeDescriptions are used to describe permissions in oauth2 flows
+	ScopeDescriptions map[string]string
+}
+
+// NewGenerator creates a generator for the schemes
+func NewGenerator(schemes map[string]Scheme) *Generator {
+	return &Generator{Schemes: schemes}
+}
+
+// SecuritySchemes returns the schemes for `components.securitySchemes`, with the
+// scopes of oauth2 flows listing every permission required by the routes.
+func (g *Generator) SecuritySchemes(routes []authmux.Route) map[string]Scheme {
+	scopes := map[string]string{}
+	for _, route := range routes {
+		for _, p := range route.Permissions {
+			scopes[p] = g.ScopeDescriptions[p]
+		}
+	}
+
+	out := make(map[string]Scheme, len(g.Schemes))
+	for name, scheme := range g.Schemes {
+		if scheme.Flows != nil {
+			flows := *scheme.Flows
+			flows.ClientCredentials = withScopes(flows.ClientCredentials, scopes)
+			flows.AuthorizationCode = withScopes(flows.AuthorizationCode, scopes)
+			scheme.Flows = &flows
+		}
+		out[name] = scheme
+	}
+	return out
+}
+
+func withScopes(flow *Flow, scopes map[string]string) *Flow {
+	if flow == nil {
+		return nil
+	}
+	out := *flow
+	out.Scopes = scopes
+	return &out
+}
+
+// Security returns the security requirements for an operation on the route.
+// Protected routes may use any of the schemes, and unprotected routes get an empty
+// list, overriding any top level requirement.
+func (g *Generator) Security(route authmux.Route) []map[string][]string {
+	security := []map[string][]string{}
+	if !route.Protected {
+		return security
+	}
+	for _, name := range g.schemeNames() {
+		scopes := []string{}
+		if g.Schemes[name].hasScopes() {
+			scopes = append(scopes, route.Permissions...)
+		}
+		security = append(security, map[string][]string{name: scopes})
+	}
+	return security
+}
+
+func (g *Generator) schemeNames() []string {
+	names := make([]string, 0, len(g.Schemes))
+	for name := range g.Schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generate returns a new spec document containing only the security schemes and
+// the operations for the routes.
+func (g *Generator) Generate(routes []authmux.Route) map[string]interface{} {
+	spec := map[string]interface{}{}
+	// a new document is always valid
+	g.Merge(spec, routes)
+	return spec
+}
+
+// Merge adds the security schemes and per-operation security requirements to a
+// decoded spec document, overwriting generated schemes and requirements, and
+// leaving everything else untouched.  Operations missing from the document are
+// added.  Routes matching any method only update the operations already in the
+// document for their path, and routes matching any path are skipped.
+func (g *Generator) Merge(spec map[string]interface{}, routes []authmux.Route) error {
+	components, err := object(spec, "components")
+	if err != nil {
+		return err
+	}
+	schemes, err := object(components, "securitySchemes")
+	if err != nil {
+		return err
+	}
+	for name, scheme := range g.SecuritySchemes(routes) {
+		schemes[name] = scheme
+	}
+
+	paths, err := object(spec, "paths")
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		// routes without a path template match every path, which can't be
+		// described as an OpenAPI path
+		if route.Path == "*" {
+			continue
+		}
+		path := PathTemplate(route.Path)
+		if _, exists := paths[path]; !exists && route.Method == "*" {
+			continue
+		}
+		item, err := object(paths, path)
+		if err != nil {
+			return err
+		}
+		methods := []string{strings.ToLower(route.Method)}
+		if route.Method == "*" {
+			methods = methods[:0]
+			for method := range item {
+				if operationMethods[method] {
+					methods = append(methods, method)
+				}
+			}
+		}
+		for _, method := range methods {
+			op, isNew, err := operation(item, method)
+			if err != nil {
+				return err
+			}
+			if isNew {
+				op["responses"] = map[string]interface{}{
+					"default": map[string]interface{}{"description": "Default response"},
+				}
+			}
+			op["security"] = g.Security(route)
+			delete(op, PermissionsExtension)
+			if len(route.Permissions) > 0 {
+				op[PermissionsExtension] = route.Permissions
+			}
+		}
+	}
+	return nil
+}
+
+// MergeJSON merges into a JSON encoded spec document, as with `Merge`
+func (g *Generator) MergeJSON(doc []byte, routes []authmux.Route) ([]byte, error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(doc, &spec); err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return nil, ErrInvalidSpec
+	}
+	if err := g.Merge(spec, routes); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+var operationMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// object returns the object at key in parent, creating it if missing
+func object(parent map[string]interface{}, key string) (map[string]interface{}, error) {
+	val, ok := parent[key]
+	if !ok || val == nil {
+		obj := map[string]interface{}{}
+		parent[key] = obj
+		return obj, nil
+	}
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidSpec
+	}
+	return obj, nil
+}
+
+func operation(item map[string]interface{}, method string) (map[string]interface{}, bool, error) {
+	_, exists := item[method]
+	op, err := object(item, method)
+	return op, !exists, err
+}
+
+// PathTemplate converts a gorilla/mux path template to an OpenAPI path, removing
+// variable patterns, e.g. `/users/{id:[0-9]{3}}` becomes `/users/{id}`.  Braces
+// are balanced as with mux, so patterns may contain braces themselves.  Paths
+// with unbalanced braces are returned unchanged.
+func PathTemplate(path string) string {
+	var buf bytes.Buffer
+	level, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			if level == 0 {
+				start = i
+			}
+			level++
+			continue
+		case '}':
+			level--
+			if level < 0 {
+				return path
+			}
+			if level == 0 {
+				name := path[start+1 : i]
+				if colon := strings.Index(name, ":"); colon >= 0 {
+					name = name[:colon]
+				}
+				buf.WriteString("{" + strings.TrimSpace(name) + "}")
+			}
+			continue
+		}
+		if level == 0 {
+			buf.WriteByte(path[i])
+		}
+	}
+	if level != 0 {
+		return path
+	}
+	return buf.String()
+}
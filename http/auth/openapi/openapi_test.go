@@ -0,0 +1,132 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/authmux"
+)
+
+func appRoutes(t *testing.T) []authmux.Route {
+	authClient := auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+	router := mux.NewRouter()
+	router.Handle("/health", h).Methods("GET")
+	router.Handle("/me", authClient(h)).Methods("GET")
+	router.Handle("/users/{id:[0-9]+}", authPerms(h, "users.read")).Methods("GET")
+	router.Handle("/users/{id:[0-9]+}", authPerms(h, "users.write")).Methods("PUT")
+	router.Handle("/anything", authClient(h))
+
+	registry, err := authmux.NewRegistry(router)
+	require.Nil(t, err)
+	return registry.Routes()
+}
+
+func TestPathTemplate(t *testing.T) {
+	require.Equal(t, "/users/{id}", PathTemplate("/users/{id:[0-9]+}"))
+	require.Equal(t, "/users/{id}/keys/{key}", PathTemplate("/users/{id}/keys/{key:[a-z]+}"))
+	require.Equal(t, "/users/{id}/keys", PathTemplate("/users/{id:[0-9]{3}}/keys"))
+	require.Equal(t, "/codes/{code}", PathTemplate("/codes/{code:[a-z]{2,}-[0-9]{1,3}}"))
+	require.Equal(t, "/users/{id", PathTemplate("/users/{id"))
+}
+
+func TestGenerate(t *testing.T) {
+	g := NewGenerator(map[string]Scheme{
+		"apiKey": APIKey("ApiKey"),
+		"oauth":  ClientCredentials("https://auth.example.com/token"),
+	})
+	g.ScopeDescriptions = map[string]string{"users.read": "Read users"}
+
+	spec := g.Generate(appRoutes(t))
+	out, err := json.Marshal(spec)
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"components": {"securitySchemes": {
+			"apiKey": {"type": "http", "scheme": "ApiKey"},
+			"oauth": {"type": "oauth2", "flows": {"clientCredentials": {
+				"tokenUrl": "https://auth.example.com/token",
+				"scopes": {"users.read": "Read users", "users.write": ""}
+			}}}
+		}},
+		"paths": {
+			"/health": {"get": {
+				"responses": {"default": {"description": "Default response"}},
+				"security": []
+			}},
+			"/me": {"get": {
+				"responses": {"default": {"description": "Default response"}},
+				"security": [{"apiKey": []}, {"oauth": []}]
+			}},
+			"/users/{id}": {
+				"get": {
+					"responses": {"default": {"description": "Default response"}},
+					"security": [{"apiKey": []}, {"oauth": ["users.read"]}],
+					"x-permissions": ["users.read"]
+				},
+				"put": {
+					"responses": {"default": {"description": "Default response"}},
+					"security": [{"apiKey": []}, {"oauth": ["users.write"]}],
+					"x-permissions": ["users.write"]
+				}
+			}
+		}
+	}`, string(out))
+}
+
+func TestMergeJSON(t *testing.T) {
+	g := NewGenerator(map[string]Scheme{"bearer": Bearer("JWT")})
+	doc := []byte(`{
+		"openapi": "3.0.0",
+		"components": {"securitySchemes": {"other": {"type": "http", "scheme": "basic"}}},
+		"paths": {
+			"/anything": {"get": {"summary": "Anything", "responses": {"200": {"description": "OK"}}}},
+			"/users/{id}": {"get": {"summary": "Get user", "security": [{"other": []}], "responses": {"200": {"description": "OK"}}}}
+		}
+	}`)
+
+	out, err := g.MergeJSON(doc, appRoutes(t))
+	require.Nil(t, err)
+
+	var spec struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			SecuritySchemes map[string]Scheme `json:"securitySchemes"`
+		} `json:"components"`
+		Paths map[string]map[string]struct {
+			Summary     string                `json:"summary"`
+			Security    []map[string][]string `json:"security"`
+			Permissions []string              `json:"x-permissions"`
+		} `json:"paths"`
+	}
+	require.Nil(t, json.Unmarshal(out, &spec))
+	require.Equal(t, "3.0.0", spec.OpenAPI)
+	require.Equal(t, map[string]Scheme{
+		"other":  {Type: "http", Scheme: "basic"},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+	}, spec.Components.SecuritySchemes)
+
+	anything := spec.Paths["/anything"]["get"]
+	require.Equal(t, "Anything", anything.Summary)
+	require.Equal(t, []map[string][]string{{"bearer": {}}}, anything.Security)
+
+	user := spec.Paths["/users/{id}"]["get"]
+	require.Equal(t, "Get user", user.Summary)
+	require.Equal(t, []map[string][]string{{"bearer": {}}}, user.Security)
+	require.Equal(t, []string{"users.read"}, user.Permissions)
+	require.Equal(t, []string{"users.write"}, spec.Paths["/users/{id}"]["put"].Permissions)
+
+	// routes matching any path are skipped
+	hostOnly := map[string]interface{}{}
+	require.Nil(t, g.Merge(hostOnly, []authmux.Route{{Method: "GET", Path: "*", Protected: true, Authenticated: true}}))
+	require.Equal(t, map[string]interface{}{}, hostOnly["paths"])
+
+	_, err = g.MergeJSON([]byte(`{"paths": []}`), nil)
+	require.Equal(t, ErrInvalidSpec, err)
+}
@@ -0,0 +1,71 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList checks passwords against a local copy of a breached password hash
+// list, such as Have I Been Pwned's Pwned Passwords.  The list is split into
+// files by the first 5 hex characters of the SHA-1 hash of each password, in the
+// same format as the k-anonymity range API: a file named `<PREFIX>.txt`,
+// containing a `<SUFFIX>:<COUNT>` line for each hash with that prefix.  Only the
+// file for the password's prefix is read, and every prefix must have a file, as
+// in a complete download of the list.
+type BreachedList struct {
+	// Dir containing the range files
+	Dir string
+	// MinCount is how many times a password must have appeared in breaches to be
+	// refused, defaults to 1
+	MinCount int
+}
+
+// NewBreachedList returns a BreachedList reading range files from the directory,
+// which must exist
+func NewBreachedList(dir string) (*BreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("password: breached list %s is not a directory", dir)
+	}
+	return &BreachedList{Dir: dir, MinCount: 1}, nil
+}
+
+// Contains reports whether the password appears in the list at least MinCount
+// times.  A missing range file is an error rather than a pass, so that an
+// incomplete copy of the list doesn't silently accept breached passwords.
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(strings.ToUpper(line), suffix) {
+			continue
+		}
+		count := 1
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			if n, err := strconv.Atoi(line[i+1:]); err == nil {
+				count = n
+			}
+		}
+		return count >= b.MinCount, nil
+	}
+	return false, scanner.Err()
+}
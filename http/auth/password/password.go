@@ -0,0 +1,175 @@
+// Package password hashes and verifies passwords for apps implementing their own
+// login, such as basic auth or session logins.
+//
+// Hashes are encoded in the PHC string format, e.g.
+// `$argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>`, so the algorithm and parameters
+// are stored with each hash.  When parameters are strengthened, or the algorithm is
+// changed, existing hashes are upgraded as users log in.
+package password
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrMismatch is returned when a password doesn't match the hash
+	ErrMismatch = errors.New("password: password does not match")
+	// ErrUnsupportedHash is returned when no accepted scheme can verify the hash
+	ErrUnsupportedHash = errors.New("password: unsupported hash")
+	// ErrInvalidHash is returned when a hash is malformed
+	ErrInvalidHash = errors.New("password: invalid hash")
+	// ErrBreached is returned when hashing a password which is in the breached
+	// password list
+	ErrBreached = errors.New("password: password has appeared in a data breach")
+)
+
+// Scheme hashes passwords with a specific algorithm and parameters
+type Scheme interface {
+	// Hash returns the encoded hash of the password, using a random salt
+	Hash(password string) (string, error)
+	// Identifies reports whether the encoded hash uses this scheme's algorithm
+	Identifies(encoded string) bool
+	// Verify checks the password against an encoded hash using this scheme's
+	// algorithm.  It also reports whether the hash was created with parameters
+	// which differ from the scheme's, and so should be rehashed.
+	Verify(password, encoded string) (rehash bool, err error)
+}
+
+// Hasher hashes passwords with a preferred scheme, and verifies passwords hashed
+// with any accepted scheme.
+type Hasher struct {
+	// Scheme used for new hashes
+	Scheme Scheme
+	// Accept lists other schemes that existing hashes are verified with
+	Accept []Scheme
+	// Breached passwords are refused by Hash, if set
+	Breached *BreachedList
+}
+
+// NewHasher creates a Hasher for new hashes with the scheme, which also accepts
+// hashes created with the other schemes.
+func NewHasher(scheme Scheme, accept ...Scheme) *Hasher {
+	return &Hasher{Scheme: scheme, Accept: accept}
+}
+
+// DefaultHasher returns a Hasher using argon2id with the default parameters, which
+// also accepts bcrypt and scrypt hashes.
+func DefaultHasher() *Hasher {
+	return NewHasher(NewArgon2id(), NewBcrypt(), NewScrypt())
+}
+
+// Hash returns the encoded hash of a new password.  If a breached password list
+// is set, and contains the password, it fails with ErrBreached.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Breached != nil {
+		breached, err := h.Breached.Contains(password)
+		if err != nil {
+			return "", err
+		}
+		if breached {
+			return "", ErrBreached
+		}
+	}
+	return h.Scheme.Hash(password)
+}
+
+// Verify checks the password against an encoded hash, returning ErrMismatch if it
+// doesn't match.  Comparisons take constant time.  If the hash was created with a
+// different scheme, or different parameters, than the hasher's preferred scheme, a
+// new hash of the password is returned, and should replace the stored hash.
+// Otherwise the returned hash is empty.
+func (h *Hasher) Verify(password, encoded string) (string, error) {
+	for _, scheme := range append([]Scheme{h.Scheme}, h.Accept...) {
+		if !scheme.Identifies(encoded) {
+			continue
+		}
+		rehash, err := scheme.Verify(password, encoded)
+		if err != nil {
+			return "", err
+		}
+		if scheme != h.Scheme || rehash {
+			return h.Scheme.Hash(password)
+		}
+		return "", nil
+	}
+	return "", ErrUnsupportedHash
+}
+
+var b64 = base64.RawStdEncoding
+
+// phc is a decoded PHC string
+type phc struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func (p phc) String() string {
+	parts := []string{"", p.id}
+	if p.version != "" {
+		parts = append(parts, "v="+p.version)
+	}
+	// params are written in the order the algorithm's docs list them
+	parts = append(parts, strings.Join(p.paramOrder(), ","), b64.EncodeToString(p.salt), b64.EncodeToString(p.hash))
+	return strings.Join(parts, "$")
+}
+
+func (p phc) paramOrder() []string {
+	var order []string
+	switch p.id {
+	case argon2idID:
+		order = []string{"m", "t", "p"}
+	case scryptID:
+		order = []string{"ln", "r", "p"}
+	}
+	out := make([]string, 0, len(order))
+	for _, name := range order {
+		out = append(out, name+"="+p.params[name])
+	}
+	return out
+}
+
+// intParam returns a positive integer parameter
+func (p phc) intParam(name string) (int, error) {
+	val, err := strconv.Atoi(p.params[name])
+	if err != nil || val <= 0 {
+		return 0, ErrInvalidHash
+	}
+	return val, nil
+}
+
+func parsePHC(encoded string) (phc, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return phc{}, ErrInvalidHash
+	}
+	p := phc{id: parts[1], params: map[string]string{}}
+	parts = parts[2:]
+	if strings.HasPrefix(parts[0], "v=") {
+		p.version = strings.TrimPrefix(parts[0], "v=")
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return phc{}, ErrInvalidHash
+	}
+	for _, param := range strings.Split(parts[0], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return phc{}, ErrInvalidHash
+		}
+		p.params[kv[0]] = kv[1]
+	}
+	var err error
+	if p.salt, err = b64.DecodeString(parts[1]); err != nil {
+		return phc{}, ErrInvalidHash
+	}
+	if p.hash, err = b64.DecodeString(parts[2]); err != nil || len(p.hash) == 0 {
+		return phc{}, ErrInvalidHash
+	}
+	return p, nil
+}
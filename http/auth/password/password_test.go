@@ -0,0 +1,121 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters, so tests run quickly
+var (
+	testArgon2id = Argon2id{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	testScrypt   = Scrypt{LogN: 10, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+	testBcrypt   = Bcrypt{Cost: bcrypt.MinCost}
+)
+
+func TestSchemes(t *testing.T) {
+	for _, test := range []struct {
+		scheme Scheme
+		prefix string
+	}{
+		{testArgon2id, "$argon2id$v=19$m=1024,t=1,p=1$"},
+		{testScrypt, "$scrypt$ln=10,r=8,p=1$"},
+		{testBcrypt, "$2a$04$"},
+	} {
+		hash, err := test.scheme.Hash("correct horse")
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(hash, test.prefix), hash)
+		require.True(t, test.scheme.Identifies(hash))
+
+		rehash, err := test.scheme.Verify("correct horse", hash)
+		require.Nil(t, err)
+		require.False(t, rehash)
+
+		_, err = test.scheme.Verify("battery staple", hash)
+		require.Equal(t, ErrMismatch, err)
+
+		// salts are random
+		other, err := test.scheme.Hash("correct horse")
+		require.Nil(t, err)
+		require.NotEqual(t, hash, other)
+	}
+}
+
+func TestKnownHashes(t *testing.T) {
+	// generated with the argon2 reference implementation and python's hashlib
+	rehash, err := NewArgon2id().Verify("password", "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc")
+	require.Nil(t, err)
+	require.True(t, rehash)
+
+	rehash, err = NewScrypt().Verify("password", "$scrypt$ln=4,r=8,p=1$c29tZXNhbHQ$7xe5L3Roj67jYaBKf3ePT2Y6rVHHGUWO44Z8iz+O6PQ")
+	require.Nil(t, err)
+	require.True(t, rehash)
+}
+
+func TestHasherRehash(t *testing.T) {
+	h := NewHasher(testArgon2id, testBcrypt, testScrypt)
+
+	hash, err := h.Hash("correct horse")
+	require.Nil(t, err)
+	updated, err := h.Verify("correct horse", hash)
+	require.Nil(t, err)
+	require.Equal(t, "", updated)
+
+	// changed parameters
+	stronger := testArgon2id
+	stronger.Time = 2
+	h.Scheme = stronger
+	updated, err = h.Verify("correct horse", hash)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(updated, "$argon2id$v=19$m=1024,t=2,p=1$"))
+
+	// changed algorithm
+	bcryptHash, err := testBcrypt.Hash("correct horse")
+	require.Nil(t, err)
+	updated, err = h.Verify("correct horse", bcryptHash)
+	require.Nil(t, err)
+	require.True(t, testArgon2id.Identifies(updated))
+
+	_, err = h.Verify("battery staple", bcryptHash)
+	require.Equal(t, ErrMismatch, err)
+	_, err = h.Verify("correct horse", "$md5$abc")
+	require.Equal(t, ErrUnsupportedHash, err)
+	_, err = h.Verify("correct horse", "$argon2id$v=19$m=1024$bad")
+	require.Equal(t, ErrInvalidHash, err)
+}
+
+func TestBreachedList(t *testing.T) {
+	_, err := NewBreachedList("testdata/missing")
+	require.NotNil(t, err)
+	_, err = NewBreachedList("testdata/breached/CBFDA.txt")
+	require.NotNil(t, err)
+
+	list, err := NewBreachedList("testdata/breached")
+	require.Nil(t, err)
+	breached, err := list.Contains("password123")
+	require.Nil(t, err)
+	require.True(t, breached)
+
+	breached, err = list.Contains("correct horse battery staple")
+	require.Nil(t, err)
+	require.False(t, breached)
+
+	// a missing range file is an error, not a pass
+	_, err = list.Contains("hunter2")
+	require.NotNil(t, err)
+
+	list.MinCount = 2
+	breached, err = list.Contains("password123")
+	require.Nil(t, err)
+	require.False(t, breached)
+
+	h := NewHasher(testBcrypt)
+	h.Breached, err = NewBreachedList("testdata/breached")
+	require.Nil(t, err)
+	_, err = h.Hash("password123")
+	require.Equal(t, ErrBreached, err)
+	_, err = h.Hash("correct horse battery staple")
+	require.Nil(t, err)
+}
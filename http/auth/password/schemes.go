@@ -0,0 +1,207 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	argon2idID = "argon2id"
+	scryptID   = "scrypt"
+)
+
+func salt(size int) ([]byte, error) {
+	s := make([]byte, size)
+	if _, err := rand.Read(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Argon2id hashes passwords with argon2id, as recommended by RFC 9106
+type Argon2id struct {
+	// Memory in KiB
+	Memory uint32
+	// Time is the number of passes over the memory
+	Time    uint32
+	Threads uint8
+	SaltLen int
+	KeyLen  uint32
+}
+
+// NewArgon2id returns an Argon2id scheme with the parameters recommended by the
+// argon2 package: 64MiB of memory, 1 pass and 4 threads.
+func NewArgon2id() Argon2id {
+	return Argon2id{Memory: 64 * 1024, Time: 1, Threads: 4, SaltLen: 16, KeyLen: 32}
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	s, err := salt(a.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	return phc{
+		id:      argon2idID,
+		version: strconv.Itoa(argon2.Version),
+		params: map[string]string{
+			"m": strconv.FormatUint(uint64(a.Memory), 10),
+			"t": strconv.FormatUint(uint64(a.Time), 10),
+			"p": strconv.FormatUint(uint64(a.Threads), 10),
+		},
+		salt: s,
+		hash: argon2.IDKey([]byte(password), s, a.Time, a.Memory, a.Threads, a.KeyLen),
+	}.String(), nil
+}
+
+func (a Argon2id) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+argon2idID+"$")
+}
+
+func (a Argon2id) Verify(password, encoded string) (bool, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	if p.version != strconv.Itoa(argon2.Version) {
+		return false, ErrUnsupportedHash
+	}
+	m, err := p.intParam("m")
+	if err != nil {
+		return false, err
+	}
+	t, err := p.intParam("t")
+	if err != nil {
+		return false, err
+	}
+	threads, err := p.intParam("p")
+	if err != nil || threads > 255 {
+		return false, ErrInvalidHash
+	}
+	hash := argon2.IDKey([]byte(password), p.salt, uint32(t), uint32(m), uint8(threads), uint32(len(p.hash)))
+	if !equal(hash, p.hash) {
+		return false, ErrMismatch
+	}
+	rehash := uint32(m) != a.Memory || uint32(t) != a.Time || uint8(threads) != a.Threads ||
+		len(p.salt) != a.SaltLen || uint32(len(p.hash)) != a.KeyLen
+	return rehash, nil
+}
+
+// Bcrypt hashes passwords with bcrypt.  Hashes use bcrypt's own format, e.g.
+// `$2a$10$<salt and hash>`, which predates the PHC string format.  Passwords
+// longer than 72 bytes can't be hashed.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt returns a Bcrypt scheme using the default cost of the bcrypt package
+func NewBcrypt() Bcrypt {
+	return Bcrypt{Cost: bcrypt.DefaultCost}
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b Bcrypt) Identifies(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, ErrMismatch
+	}
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	return cost != b.Cost, nil
+}
+
+// Scrypt hashes passwords with scrypt.  The cost parameter N is stored as its base
+// 2 logarithm, `ln`.
+type Scrypt struct {
+	LogN    int
+	R       int
+	P       int
+	SaltLen int
+	KeyLen  int
+}
+
+// NewScrypt returns a Scrypt scheme with the parameters recommended by the scrypt
+// package for interactive logins: N=32768, r=8 and p=1.
+func NewScrypt() Scrypt {
+	return Scrypt{LogN: 15, R: 8, P: 1, SaltLen: 16, KeyLen: 32}
+}
+
+func (s Scrypt) Hash(password string) (string, error) {
+	salt, err := salt(s.SaltLen)
+	if err != nil {
+		return "", err
+	}
+	hash, err := scrypt.Key([]byte(password), salt, 1<<uint(s.LogN), s.R, s.P, s.KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return phc{
+		id: scryptID,
+		params: map[string]string{
+			"ln": strconv.Itoa(s.LogN),
+			"r":  strconv.Itoa(s.R),
+			"p":  strconv.Itoa(s.P),
+		},
+		salt: salt,
+		hash: hash,
+	}.String(), nil
+}
+
+func (s Scrypt) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+scryptID+"$")
+}
+
+func (s Scrypt) Verify(password, encoded string) (bool, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return false, err
+	}
+	ln, err := p.intParam("ln")
+	if err != nil || ln > 31 {
+		return false, ErrInvalidHash
+	}
+	r, err := p.intParam("r")
+	if err != nil {
+		return false, err
+	}
+	par, err := p.intParam("p")
+	if err != nil {
+		return false, err
+	}
+	hash, err := scrypt.Key([]byte(password), p.salt, 1<<uint(ln), r, par, len(p.hash))
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	if !equal(hash, p.hash) {
+		return false, ErrMismatch
+	}
+	rehash := ln != s.LogN || r != s.R || par != s.P || len(p.salt) != s.SaltLen || len(p.hash) != s.KeyLen
+	return rehash, nil
+}
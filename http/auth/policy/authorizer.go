@@ -0,0 +1,228 @@
+package policy
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/watch"
+)
+
+// Attributer is implemented by principals exposing attributes to policies, which
+// are available under `principal`, e.g. `principal.roles`.
+type Attributer interface {
+	Attributes() map[string]interface{}
+}
+
+// Engine evaluates the current policy against requests.  The policy can be
+// replaced at any time, for example when its file changes.
+type Engine struct {
+	// PathVars returns the path variables of a request, available under
+	// `request.vars`.  For gorilla/mux routers use `mux.Vars`.
+	PathVars func(r *http.Request) map[string]string
+	// Resource returns attributes of the resource a request is for, available
+	// under `resource`, e.g. by loading it from a database.  It's only called
+	// for authenticated requests.
+	Resource func(r *http.Request) (map[string]interface{}, error)
+	// OnError, if set, is called with each *RuleError, as they refuse
+	// permissions rather than failing requests
+	OnError func(r *http.Request, err error)
+
+	policy atomic.Value
+}
+
+// NewEngine creates an Engine evaluating the policy
+func NewEngine(p *Policy) *Engine {
+	e := &Engine{}
+	e.SetPolicy(p)
+	return e
+}
+
+// Policy returns the current policy
+func (e *Engine) Policy() *Policy {
+	p, _ := e.policy.Load().(*Policy)
+	return p
+}
+
+// SetPolicy atomically replaces the current policy
+func (e *Engine) SetPolicy(p *Policy) {
+	e.policy.Store(p)
+}
+
+// Input returns the attributes of the principal and request that rules are
+// evaluated against.
+//
+// The principal's attributes are `id`, `permissions` if it implements
+// `auth.PermissionLister`, `tenant` if it implements `auth.TenantAuthenticator`,
+// and any returned by `Attributes` if it implements Attributer.
+//
+// The request's attributes are `method`, `path`, `host`, `ip`, `vars`, `query`
+// and `headers`.  Header names are lowercased, and only the first value of each
+// header and query parameter is included.
+func (e *Engine) Input(r *http.Request, principal interface{}) (Input, error) {
+	input := Input{
+		Principal: principalAttributes(principal),
+		Request:   e.requestAttributes(r),
+	}
+	if e.Resource != nil {
+		resource, err := e.Resource(r)
+		if err != nil {
+			return input, err
+		}
+		input.Resource = resource
+	}
+	return input, nil
+}
+
+func principalAttributes(principal interface{}) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	if a, ok := principal.(auth.Authenticator); ok {
+		attrs["id"] = a.AuthenticationID()
+	}
+	if l, ok := principal.(auth.PermissionLister); ok {
+		attrs["permissions"] = l.Permissions()
+	}
+	if t, ok := principal.(auth.TenantAuthenticator); ok {
+		attrs["tenant"] = t.TenantID()
+	}
+	if a, ok := principal.(Attributer); ok {
+		for k, v := range a.Attributes() {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func (e *Engine) requestAttributes(r *http.Request) map[string]interface{} {
+	vars := map[string]interface{}{}
+	if e.PathVars != nil {
+		for k, v := range e.PathVars(r) {
+			vars[k] = v
+		}
+	}
+	headers := map[string]interface{}{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = v[0]
+	}
+	query := map[string]interface{}{}
+	for k, v := range r.URL.Query() {
+		query[k] = v[0]
+	}
+	return map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"host":    r.Host,
+		"ip":      auth.RemoteIP(r),
+		"vars":    vars,
+		"headers": headers,
+		"query":   query,
+	}
+}
+
+// Authorize checks that the principal is authenticated, and that the policy grants
+// it every permission for the request.  It returns `auth.ErrPermissionDenied` for
+// the first permission refused, including those refused because a rule failed to
+// evaluate.
+func (e *Engine) Authorize(r *http.Request, principal interface{}, perms ...string) error {
+	if err := auth.CheckClient(principal); err != nil {
+		return err
+	}
+	p := e.Policy()
+	if p == nil {
+		return auth.ErrAuthorizationFailed
+	}
+	input, err := e.Input(r, principal)
+	if err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		decision, err := p.Evaluate(input, perm)
+		if _, ok := err.(*RuleError); ok {
+			if e.OnError != nil {
+				e.OnError(r, err)
+			}
+		} else if err != nil {
+			return err
+		}
+		if !decision.Allowed {
+			return auth.NewErrPermissionDenied(perm)
+		}
+	}
+	return nil
+}
+
+// WatchFile loads the policy file, and polls it for changes at the interval,
+// replacing the engine's policy when it changes.  Invalid policies are reported to
+// onError, which may be nil, and the previous policy is kept.  The returned
+// function stops watching.
+func (e *Engine) WatchFile(path string, interval time.Duration, onError func(error)) (stop func()) {
+	s := watch.NewFile(path, func(data []byte) (interface{}, error) {
+		return Parse(data)
+	})
+	e.WatchSource(s, onError)
+	s.Reload()
+	return s.Watch(interval)
+}
+
+// WatchSource replaces the engine's policy whenever the source reloads a new one.
+// The source must parse policies with `Parse`, and must not be loaded yet.
+// Invalid policies are reported to onError, which may be nil.
+func (e *Engine) WatchSource(s *watch.Source, onError func(error)) {
+	next := s.OnReload
+	s.OnReload = func(ev watch.Event) {
+		if ev.Err != nil && onError != nil {
+			onError(ev.Err)
+		}
+		if p, ok := ev.Value.(*Policy); ok && ev.Changed {
+			e.SetPolicy(p)
+		}
+		if next != nil {
+			next(ev)
+		}
+	}
+}
+
+// NewPolicyAuthorizer returns an authorization middleware factory, like
+// `auth.NewPermissionsAuthorizer`, whose handlers only execute if the engine's
+// policy grants the object in the request context at the specified key every
+// specified permission.
+//
+// The returned handlers implement `auth.ProtectedHandler`.
+func NewPolicyAuthorizer(keyname string, failFn auth.ErrorHandler, engine *Engine) func(http.Handler, ...string) http.Handler {
+	return func(handler http.Handler, perms ...string) http.Handler {
+		return auth.Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if err := checkPolicy(keyname, engine, r, perms...); err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			handler.ServeHTTP(rw, r)
+		}), handler, auth.Requirement{Authenticated: true, Permissions: perms})
+	}
+}
+
+// NewPolicyAuthorizerMiddleware returns a negroni-style middleware factory for
+// invoking policy checks
+func NewPolicyAuthorizerMiddleware(keyname string, failFn auth.ErrorHandler, engine *Engine) func(...string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(perms ...string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+		return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+			if err := checkPolicy(keyname, engine, r, perms...); err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			next(rw, r)
+		}
+	}
+}
+
+func checkPolicy(keyname string, engine *Engine, r *http.Request, perms ...string) error {
+	principal := r.Context().Value(keyname)
+	err := engine.Authorize(r, principal, perms...)
+	if err != nil {
+		auth.NotifyDenied(r, principal, err)
+		return err
+	}
+	auth.NotifyAuthorized(r, principal)
+	return nil
+}
@@ -0,0 +1,671 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The expression language is deliberately small.  Expressions are made of:
+//
+//	literals      "string", 'string', 42, 1.5, true, false, null, ["a", "b"]
+//	attributes    principal.id, request.vars.id, request.headers["x-tenant"]
+//	comparisons   == != < <= > >=
+//	membership    "admin" in principal.roles, "x-tenant" in request.headers
+//	logic         && || ! and parentheses
+//	functions     startsWith(s, prefix), endsWith(s, suffix), lower(s)
+//
+// Attributes which don't exist are null.  Null is false where a boolean is
+// expected, and membership in null is false, so conditions on optional flags and
+// lists don't fail when they're missing.  Comparing a missing attribute is an
+// evaluation error though, as neither true nor false is safe: a missing attribute
+// mustn't match another in an allow rule, e.g. `principal.org == resource.org`,
+// and mustn't fail to differ from another in a deny rule, e.g.
+// `principal.org != resource.org`.  Errors never grant access, as allow rules
+// which fail don't match, and deny rules which fail refuse the permission.  The
+// exception is comparing with the null literal, so `principal.org == null` checks
+// whether the attribute is missing.
+
+// roots are the attribute namespaces expressions may refer to
+var roots = map[string]bool{"principal": true, "request": true, "resource": true}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// SyntaxError is returned when an expression can't be parsed
+type SyntaxError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("policy: %s at position %d in %q", e.Msg, e.Pos, e.Expr)
+}
+
+var comparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(expr) {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(expr) && (expr[i] == '_' || unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, expr[start:i], start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(expr) && (unicode.IsDigit(rune(expr[i])) || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, expr[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			var s []byte
+			for i++; i < len(expr) && rune(expr[i]) != c; i++ {
+				if expr[i] == '\\' && i+1 < len(expr) {
+					i++
+				}
+				s = append(s, expr[i])
+			}
+			if i >= len(expr) {
+				return nil, &SyntaxError{expr, start, "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, token{tokString, string(s), start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &SyntaxError{expr, i, fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(expr)}), nil
+}
+
+// node is a parsed expression
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type parser struct {
+	expr   string
+	tokens []token
+	pos    int
+}
+
+// compile parses an expression
+func compile(expr string) (node, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{expr: expr, tokens: tokens}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return p.errorf(tok, "expected %q", op)
+	}
+	return nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &SyntaxError{p.expr, tok.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{"||", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{"&&", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	var op string
+	switch {
+	case tok.kind == tokOp && comparisons[tok.text]:
+		op = tok.text
+	case tok.kind == tokIdent && tok.text == "in":
+		op = "in"
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op, left, right}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literal{tok.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "invalid number %q", tok.text)
+		}
+		return literal{f}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(tok)
+		}
+		if !roots[tok.text] {
+			return nil, p.errorf(tok, "unknown attribute %q", tok.text)
+		}
+		return p.parsePath(tok.text)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			var items []node
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return listNode{items}, nil
+		}
+	}
+	if tok.kind == tokEOF {
+		return nil, p.errorf(tok, "unexpected end of expression")
+	}
+	return nil, p.errorf(tok, "unexpected %q", tok.text)
+}
+
+func (p *parser) parsePath(root string) (node, error) {
+	n := node(attrNode{root})
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, p.errorf(tok, "expected attribute name")
+			}
+			n = indexNode{n, literal{tok.text}}
+		case p.accept("["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = indexNode{n, key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"startsWith": stringFunc(func(args []string) interface{} { return strings.HasPrefix(args[0], args[1]) }, 2),
+	"endsWith":   stringFunc(func(args []string) interface{} { return strings.HasSuffix(args[0], args[1]) }, 2),
+	"lower":      stringFunc(func(args []string) interface{} { return strings.ToLower(args[0]) }, 1),
+}
+
+// stringFunc wraps a function of strings.  Calls with null arguments return null.
+func stringFunc(fn func([]string) interface{}, arity int) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != arity {
+			return nil, fmt.Errorf("expected %d arguments, got %d", arity, len(args))
+		}
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string argument, got %T", arg)
+			}
+			strs[i] = s
+		}
+		return fn(strs), nil
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.text)
+	}
+	var args []node
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return callNode{name.text, fn, args}, nil
+}
+
+type literal struct {
+	val interface{}
+}
+
+func (l literal) eval(env map[string]interface{}) (interface{}, error) {
+	return l.val, nil
+}
+
+type attrNode struct {
+	name string
+}
+
+func (a attrNode) eval(env map[string]interface{}) (interface{}, error) {
+	return normalize(env[a.name]), nil
+}
+
+type indexNode struct {
+	target node
+	key    node
+}
+
+func (n indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("policy: can't index object with %T", key)
+		}
+		return normalize(t[k]), nil
+	case []interface{}:
+		f, ok := key.(float64)
+		if !ok {
+			return nil, fmt.Errorf("policy: can't index list with %T", key)
+		}
+		if i := int(f); float64(i) == f && i >= 0 && i < len(t) {
+			return normalize(t[i]), nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("policy: can't index %T", target)
+}
+
+type listNode struct {
+	items []node
+}
+
+func (l listNode) eval(env map[string]interface{}) (interface{}, error) {
+	out := make([]interface{}, len(l.items))
+	for i, item := range l.items {
+		val, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = val
+	}
+	return out, nil
+}
+
+type callNode struct {
+	name string
+	fn   func([]interface{}) (interface{}, error)
+	args []node
+}
+
+func (c callNode) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		val, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+	out, err := c.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %s: %s", c.name, err)
+	}
+	return out, nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n notNode) eval(env map[string]interface{}) (interface{}, error) {
+	val, err := evalBool(n.operand, env)
+	return !val, err
+}
+
+type logicNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" && !left || n.op == "||" && left {
+		return left, nil
+	}
+	return evalBool(n.right, env)
+}
+
+// evalBool evaluates a node which must result in a boolean.  Null is treated as
+// false, so conditions on missing attributes don't fail.
+func evalBool(n node, env map[string]interface{}) (bool, error) {
+	val, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	}
+	return false, fmt.Errorf("policy: expected boolean, got %T", val)
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		var eq bool
+		if isNull(n.left) || isNull(n.right) {
+			eq = left == nil && right == nil
+		} else if eq, err = equal(left, right); err != nil {
+			return nil, err
+		}
+		// != is always the negation of ==
+		return eq == (n.op == "=="), nil
+	case "in":
+		return contains(right, left)
+	}
+
+	if left == nil || right == nil {
+		return nil, errMissingAttribute
+	}
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("policy: can't compare number with %T", right)
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("policy: can't compare string with %T", right)
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("policy: can't order %T", left)
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// isNull returns whether a node is the null literal
+func isNull(n node) bool {
+	l, ok := n.(literal)
+	return ok && l.val == nil
+}
+
+// errMissingAttribute is returned when comparing a missing attribute
+var errMissingAttribute = errors.New("policy: can't compare a missing attribute, use `== null` to check for one")
+
+// equal compares values.  Comparing null, including items of lists, is an error.
+func equal(a, b interface{}) (bool, error) {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return false, errMissingAttribute
+	}
+	if al, ok := a.([]interface{}); ok {
+		bl, ok := b.([]interface{})
+		if !ok || len(al) != len(bl) {
+			return false, nil
+		}
+		for i := range al {
+			if eq, err := equal(al[i], bl[i]); err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return reflect.DeepEqual(a, b), nil
+}
+
+// contains checks membership of val in a list, an object's keys, or a string
+func contains(collection, val interface{}) (bool, error) {
+	if collection == nil {
+		return false, nil
+	}
+	if val == nil {
+		return false, errMissingAttribute
+	}
+	switch c := collection.(type) {
+	case []interface{}:
+		for _, item := range c {
+			if item == nil {
+				continue
+			}
+			if eq, err := equal(item, val); err != nil || eq {
+				return eq, err
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		k, ok := val.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := c[k]
+		return exists, nil
+	case string:
+		s, ok := val.(string)
+		return ok && strings.Contains(c, s), nil
+	}
+	return false, fmt.Errorf("policy: can't check membership in %T", collection)
+}
+
+// normalize converts attribute values supplied by apps into the types the
+// language works with: numbers become float64, and string lists and maps become
+// generic lists and objects.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		out := make([]interface{}, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			out[k] = s
+		}
+		return out
+	}
+	return val
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpressions(t *testing.T) {
+	env := map[string]interface{}{
+		"principal": map[string]interface{}{
+			"id":    "user-1",
+			"roles": []string{"editor", "reviewer"},
+			"level": 3,
+		},
+		"request": map[string]interface{}{
+			"method":  "PUT",
+			"vars":    map[string]string{"id": "user-1"},
+			"headers": map[string]interface{}{"x-tenant": "acme"},
+		},
+		"resource": nil,
+	}
+
+	tests := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{`principal.id == "user-1"`, true},
+		{`principal.id == request.vars.id`, true},
+		{`principal.id != 'user-1'`, false},
+		{`"editor" in principal.roles`, true},
+		{`"admin" in principal.roles`, false},
+		{`principal.roles[1] == "reviewer"`, true},
+		{`principal.roles[5] == null`, true},
+		{`request.headers["x-tenant"] == "acme"`, true},
+		{`"x-tenant" in request.headers`, true},
+		{`request.method in ["PUT", "PATCH"]`, true},
+		{`principal.level >= 3 && principal.level < 4`, true},
+		{`principal.level > 3 || principal.id == "user-1"`, true},
+		{`!(principal.level > 3)`, true},
+		{`principal.missing == null`, true},
+		{`principal.missing.deeper == null`, true},
+		{`startsWith(principal.id, "user-")`, true},
+		{`endsWith(lower("ABC"), "c")`, true},
+		{`startsWith(principal.missing, "user-")`, nil},
+		{`"ed" in principal.roles[0]`, true},
+		{`principal.roles == ["editor", "reviewer"]`, true},
+		{`principal.id != null`, true},
+		{`null == null`, true},
+		{`principal.id in [null, "user-1"]`, true},
+		{`"editor" in principal.missing`, false},
+	}
+	for _, test := range tests {
+		n, err := compile(test.expr)
+		require.Nil(t, err, test.expr)
+		val, err := n.eval(env)
+		require.Nil(t, err, test.expr)
+		require.Equal(t, test.expected, val, test.expr)
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, expr := range []string{
+		`principal.id ==`,
+		`principal.id == "unterminated`,
+		`user.id == "x"`,
+		`unknown(principal.id)`,
+		`(principal.id == "x"`,
+		`principal.id == "x" extra`,
+		`principal.id = "x"`,
+		`principal.`,
+	} {
+		_, err := compile(expr)
+		_, ok := err.(*SyntaxError)
+		require.True(t, ok, "%s: %v", expr, err)
+	}
+
+	env := map[string]interface{}{"principal": map[string]interface{}{"id": "user-1", "level": 3}}
+	for _, expr := range []string{
+		`principal.level > "3"`,
+		`principal.id && true`,
+		`principal.id in principal.level`,
+		`startsWith(principal.level, "1")`,
+		`principal.missing == principal.id`,
+		`principal.id == principal.missing`,
+		`principal.missing == principal.other`,
+		`principal.missing != principal.other`,
+		`principal.missing != "user-1"`,
+		`!(principal.missing == "user-1")`,
+		`principal.missing > 3`,
+		`[principal.missing] == [principal.other]`,
+		`principal.missing in [null]`,
+		`principal.missing in "user-1"`,
+	} {
+		n, err := compile(expr)
+		require.Nil(t, err, expr)
+		_, err = evalBool(n, env)
+		require.NotNil(t, err, expr)
+	}
+}
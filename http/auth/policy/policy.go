@@ -0,0 +1,190 @@
+// Package policy authorizes requests with rules written in a small embedded
+// expression language, evaluated over attributes of the principal, the request,
+// and the resource being accessed.  It's intended for checks which permission
+// strings alone can't express, such as "users may edit their own profile".
+//
+// Policies are JSON documents listing rules:
+//
+//	{"rules": [
+//		{"name": "admins", "effect": "allow", "permissions": ["*"],
+//		 "when": "'admin' in principal.roles"},
+//		{"name": "edit own profile", "effect": "allow", "permissions": ["users.write"],
+//		 "when": "principal.id == request.vars.id"},
+//		{"name": "no offsite writes", "effect": "deny", "permissions": ["users.write"],
+//		 "when": "!startsWith(request.ip, '10.')"}
+//	]}
+//
+// A permission is granted when an allow rule for it matches, and no deny rule for
+// it matches.  Rules with no condition always match.  See expr.go for the
+// expression language.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	// Allow rules grant permissions when their condition matches
+	Allow = "allow"
+	// Deny rules refuse permissions when their condition matches, regardless of
+	// any allow rules
+	Deny = "deny"
+)
+
+// Rule grants or refuses permissions when its condition matches
+type Rule struct {
+	Name   string `json:"name"`
+	Effect string `json:"effect"`
+	// Permissions the rule applies to, or `*` for every permission
+	Permissions []string `json:"permissions"`
+	// When is the rule's condition, an expression which must evaluate to true
+	// for the rule to match.  An empty condition always matches.
+	When string `json:"when"`
+
+	cond node
+}
+
+func (r *Rule) appliesTo(perm string) bool {
+	for _, p := range r.Permissions {
+		if p == perm || p == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is a compiled set of rules
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Parse parses and compiles a JSON policy document, failing if any rule is
+// invalid.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if err := p.Compile(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LoadFile parses the policy document in the file
+func LoadFile(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Compile validates and compiles the rules of a policy built in code
+func (p *Policy) Compile() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return fmt.Errorf("policy: rule %q has invalid effect %q", rule.Name, rule.Effect)
+		}
+		if rule.When == "" {
+			rule.cond = literal{true}
+			continue
+		}
+		cond, err := compile(rule.When)
+		if err != nil {
+			return fmt.Errorf("policy: rule %q: %s", rule.Name, err)
+		}
+		rule.cond = cond
+	}
+	return nil
+}
+
+// Input holds the attributes rules are evaluated against
+type Input struct {
+	Principal map[string]interface{} `json:"principal"`
+	Request   map[string]interface{} `json:"request"`
+	Resource  map[string]interface{} `json:"resource"`
+}
+
+// Decision is the outcome of evaluating a permission
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the rule which decided the outcome, or empty if no
+	// rule matched, and the permission was refused by default
+	Rule string
+}
+
+// RuleError is returned when a rule's condition fails to evaluate, for example by
+// comparing a number with a string
+type RuleError struct {
+	rule string
+	err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("policy: rule %q: %s", e.rule, e.err)
+}
+
+// Rule returns the name of the rule which failed
+func (e *RuleError) Rule() string {
+	return e.rule
+}
+
+// Err returns the evaluation error
+func (e *RuleError) Err() error {
+	return e.err
+}
+
+// Evaluate decides whether the input is granted the permission.
+//
+// Rules which fail to evaluate are reported with a *RuleError, but don't stop
+// the remaining rules from being evaluated, and the decision is still valid.  A
+// failed allow rule doesn't match, and a failed deny rule refuses the permission,
+// so that errors never grant access.
+func (p *Policy) Evaluate(input Input, perm string) (Decision, error) {
+	env := map[string]interface{}{
+		"principal": input.Principal,
+		"request":   input.Request,
+		"resource":  input.Resource,
+	}
+
+	allowedBy := ""
+	allowed := false
+	var ruleErr error
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.appliesTo(perm) {
+			continue
+		}
+		if rule.cond == nil {
+			return Decision{}, fmt.Errorf("policy: rule %q was not compiled", rule.Name)
+		}
+		matched, err := evalBool(rule.cond, env)
+		if err != nil {
+			err = &RuleError{rule.Name, err}
+			if rule.Effect == Deny {
+				return Decision{Allowed: false, Rule: rule.Name}, err
+			}
+			if ruleErr == nil {
+				ruleErr = err
+			}
+			continue
+		}
+		if !matched {
+			continue
+		}
+		if rule.Effect == Deny {
+			return Decision{Allowed: false, Rule: rule.Name}, nil
+		}
+		if !allowed {
+			allowed, allowedBy = true, rule.Name
+		}
+	}
+	if allowed {
+		return Decision{Allowed: true, Rule: allowedBy}, nil
+	}
+	return Decision{Allowed: false}, ruleErr
+}
@@ -0,0 +1,216 @@
+package policy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+const testPolicy = `{"rules": [
+	{"name": "admins", "effect": "allow", "permissions": ["*"], "when": "'admin' in principal.roles"},
+	{"name": "edit own profile", "effect": "allow", "permissions": ["users.write"], "when": "principal.id == request.vars.id"},
+	{"name": "read published", "effect": "allow", "permissions": ["posts.read"], "when": "resource.published"},
+	{"name": "no offsite writes", "effect": "deny", "permissions": ["users.write"], "when": "!startsWith(request.ip, '10.')"}
+]}`
+
+type testPrincipal struct {
+	id    string
+	roles []string
+}
+
+func (p testPrincipal) AuthenticationID() string {
+	return p.id
+}
+
+func (p testPrincipal) Attributes() map[string]interface{} {
+	return map[string]interface{}{"roles": p.roles}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.Nil(t, err)
+	require.Len(t, p.Rules, 4)
+
+	_, err = Parse([]byte(`{"rules": [{"name": "bad", "effect": "allow", "when": "principal.id =="}]}`))
+	require.NotNil(t, err)
+	_, err = Parse([]byte(`{"rules": [{"name": "bad", "effect": "maybe"}]}`))
+	require.NotNil(t, err)
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.Nil(t, err)
+
+	input := func(id, ip string, roles ...string) Input {
+		return Input{
+			Principal: map[string]interface{}{"id": id, "roles": roles},
+			Request:   map[string]interface{}{"ip": ip, "vars": map[string]interface{}{"id": "user-1"}},
+		}
+	}
+
+	d, err := p.Evaluate(input("user-1", "10.0.0.1"), "users.write")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: true, Rule: "edit own profile"}, d)
+
+	d, err = p.Evaluate(input("user-2", "10.0.0.1"), "users.write")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: false}, d)
+
+	// deny rules override allow rules
+	d, err = p.Evaluate(input("user-1", "192.0.2.1", "admin"), "users.write")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: false, Rule: "no offsite writes"}, d)
+
+	d, err = p.Evaluate(input("user-2", "192.0.2.1", "admin"), "deploys.create")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: true, Rule: "admins"}, d)
+
+	// comparing missing attributes is an error, so they never match each other
+	d, err = p.Evaluate(Input{Principal: map[string]interface{}{}, Request: map[string]interface{}{"ip": "10.0.0.1"}}, "users.write")
+	require.IsType(t, &RuleError{}, err)
+	require.Equal(t, Decision{Allowed: false}, d)
+
+	orgs, err := Parse([]byte(`{"rules": [{"name": "same org", "effect": "allow", "permissions": ["*"], "when": "resource.org == principal.org"}]}`))
+	require.Nil(t, err)
+	d, err = orgs.Evaluate(Input{Principal: map[string]interface{}{"id": "user-1"}}, "users.read")
+	require.IsType(t, &RuleError{}, err)
+	require.Equal(t, Decision{Allowed: false}, d)
+
+	// and never fail to differ from each other
+	orgs, err = Parse([]byte(`{"rules": [
+		{"name": "all", "effect": "allow", "permissions": ["*"]},
+		{"name": "other org", "effect": "deny", "permissions": ["*"], "when": "principal.org != resource.org"}
+	]}`))
+	require.Nil(t, err)
+	in := Input{Principal: map[string]interface{}{"id": "user-1"}, Resource: map[string]interface{}{"org": "acme"}}
+	d, err = orgs.Evaluate(in, "users.read")
+	require.IsType(t, &RuleError{}, err)
+	require.Equal(t, Decision{Allowed: false, Rule: "other org"}, d)
+
+	in.Principal["org"] = "acme"
+	d, err = orgs.Evaluate(in, "users.read")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: true, Rule: "all"}, d)
+}
+
+func TestEvaluateRuleErrors(t *testing.T) {
+	p, err := Parse([]byte(`{"rules": [
+		{"name": "published", "effect": "allow", "permissions": ["posts.read"], "when": "resource.published"},
+		{"name": "owner", "effect": "allow", "permissions": ["posts.read"], "when": "resource.owner == principal.id"},
+		{"name": "suspended", "effect": "deny", "permissions": ["posts.write"], "when": "principal.suspended"}
+	]}`))
+	require.Nil(t, err)
+
+	// a failed allow rule doesn't stop later rules from matching
+	input := Input{
+		Principal: map[string]interface{}{"id": "user-1"},
+		Resource:  map[string]interface{}{"published": "yes", "owner": "user-1"},
+	}
+	d, err := p.Evaluate(input, "posts.read")
+	require.Nil(t, err)
+	require.Equal(t, Decision{Allowed: true, Rule: "owner"}, d)
+
+	// and is reported if nothing else grants the permission
+	input.Resource["owner"] = "user-2"
+	d, err = p.Evaluate(input, "posts.read")
+	require.Equal(t, Decision{Allowed: false}, d)
+	ruleErr, ok := err.(*RuleError)
+	require.True(t, ok)
+	require.Equal(t, "published", ruleErr.Rule())
+
+	// a failed deny rule refuses the permission
+	input.Principal["suspended"] = "no"
+	d, err = p.Evaluate(input, "posts.write")
+	require.Equal(t, Decision{Allowed: false, Rule: "suspended"}, d)
+	require.IsType(t, &RuleError{}, err)
+}
+
+func TestNewPolicyAuthorizer(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	require.Nil(t, err)
+	engine := NewEngine(p)
+	engine.PathVars = func(r *http.Request) map[string]string {
+		return map[string]string{"id": filepath.Base(r.URL.Path)}
+	}
+	engine.Resource = func(r *http.Request) (map[string]interface{}, error) {
+		return map[string]interface{}{"published": r.URL.Query().Get("draft") == ""}, nil
+	}
+
+	authPolicy := NewPolicyAuthorizer("ApiClient", auth.StandardErrorHandler, engine)
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	call := func(handler http.Handler, principal interface{}, target string) int {
+		r := httptest.NewRequest("GET", "http://example.com"+target, nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if principal != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", principal))
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	users := authPolicy(h, "users.write")
+	require.Equal(t, 200, call(users, testPrincipal{id: "user-1"}, "/users/user-1"))
+	require.Equal(t, 403, call(users, testPrincipal{id: "user-1"}, "/users/user-2"))
+	require.Equal(t, 200, call(users, testPrincipal{id: "user-1", roles: []string{"admin"}}, "/users/user-2"))
+	require.Equal(t, 401, call(users, nil, "/users/user-1"))
+
+	posts := authPolicy(h, "posts.read")
+	require.Equal(t, 200, call(posts, testPrincipal{id: "user-1"}, "/posts/1"))
+	require.Equal(t, 403, call(posts, testPrincipal{id: "user-1"}, "/posts/1?draft=1"))
+
+	// rules which fail to evaluate refuse the permission, and are reported
+	var errs []error
+	engine.OnError = func(r *http.Request, err error) { errs = append(errs, err) }
+	engine.Resource = func(r *http.Request) (map[string]interface{}, error) {
+		return map[string]interface{}{"published": "yes"}, nil
+	}
+	require.Equal(t, 403, call(posts, testPrincipal{id: "user-1"}, "/posts/1"))
+	require.Len(t, errs, 1)
+	require.Equal(t, "read published", errs[0].(*RuleError).Rule())
+
+	req, ok := users.(auth.ProtectedHandler)
+	require.True(t, ok)
+	require.Equal(t, auth.Requirement{Authenticated: true, Permissions: []string{"users.write"}}, req.Requirement())
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"rules": []}`), 0644))
+
+	errs := make(chan error, 10)
+	engine := NewEngine(nil)
+	stop := engine.WatchFile(path, 10*time.Millisecond, func(err error) { errs <- err })
+	defer stop()
+	require.Len(t, engine.Policy().Rules, 0)
+
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for reload")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	require.Nil(t, ioutil.WriteFile(path, []byte(testPolicy), 0644))
+	waitFor(func() bool { return len(engine.Policy().Rules) == 4 })
+
+	// invalid policies are reported, and the last good policy is kept
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"rules": [{"effect": "allow", "when": "=="}]}`), 0644))
+	require.NotNil(t, <-errs)
+	require.Len(t, engine.Policy().Rules, 4)
+}
@@ -0,0 +1,248 @@
+// Package ratelimit provides middlewares for throttling requests per authenticated
+// principal.  Requests are keyed on the `auth.Authenticator` found in the request
+// context, falling back to the client IP address for anonymous requests.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+// Limit describes how many requests are allowed within a period.  A zero Limit
+// disables rate limiting.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Period > 0
+}
+
+// PermissionLimit applies a Limit to any principal granted the permission
+type PermissionLimit struct {
+	Permission string
+	Limit      Limit
+}
+
+// Result describes the outcome of attempting to take a request from a Limit
+type Result struct {
+	Allowed bool
+	// Limit is the total number of requests allowed per period
+	Limit int
+	// Remaining is the number of requests left before the client is limited
+	Remaining int
+	// Reset is how long until the limit is fully replenished
+	Reset time.Duration
+	// RetryAfter is how long the client must wait if the request was not allowed
+	RetryAfter time.Duration
+}
+
+// Algorithm updates the stored state for a key when a request is made, and reports
+// whether or not the request is allowed.
+type Algorithm interface {
+	Take(state *State, limit Limit, now time.Time) Result
+}
+
+var (
+	// TokenBucket allows bursts of up to `Limit.Requests`, replenishing tokens
+	// evenly over `Limit.Period`.
+	TokenBucket Algorithm = tokenBucket{}
+	// SlidingWindow allows `Limit.Requests` per `Limit.Period`, approximating a
+	// sliding window by weighting the count from the previous fixed window.
+	SlidingWindow Algorithm = slidingWindow{}
+)
+
+type tokenBucket struct{}
+
+func (tokenBucket) Take(s *State, l Limit, now time.Time) Result {
+	capacity := float64(l.Requests)
+	rate := capacity / l.Period.Seconds()
+
+	// refill the bucket based on time elapsed since the last request
+	if s.Start.IsZero() {
+		s.Tokens = capacity
+	} else if elapsed := now.Sub(s.Start).Seconds(); elapsed > 0 {
+		s.Tokens = math.Min(capacity, s.Tokens+elapsed*rate)
+	}
+	s.Start = now
+
+	res := Result{Limit: l.Requests}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - s.Tokens) / rate)
+	}
+	res.Remaining = int(math.Floor(s.Tokens))
+	res.Reset = seconds((capacity - s.Tokens) / rate)
+	return res
+}
+
+type slidingWindow struct{}
+
+func (slidingWindow) Take(s *State, l Limit, now time.Time) Result {
+	// roll the window forward, keeping the previous count only if the previous
+	// window immediately precedes the current one
+	window := now.Truncate(l.Period)
+	if !s.Start.Equal(window) {
+		if s.Start.Add(l.Period).Equal(window) {
+			s.PrevCount = s.Count
+		} else {
+			s.PrevCount = 0
+		}
+		s.Count = 0
+		s.Start = window
+	}
+
+	elapsed := now.Sub(window)
+	weight := 1 - float64(elapsed)/float64(l.Period)
+	estimate := float64(s.PrevCount)*weight + float64(s.Count)
+	limit := float64(l.Requests)
+
+	res := Result{Limit: l.Requests, Reset: l.Period - elapsed}
+	if estimate+1 <= limit {
+		s.Count++
+		estimate++
+		res.Allowed = true
+	} else if s.PrevCount > 0 && float64(s.Count)+1 <= limit {
+		// wait until the previous window's weight has decayed enough to
+		// make room for one more request
+		target := (limit - float64(s.Count) - 1) / float64(s.PrevCount)
+		res.RetryAfter = time.Duration((1-target)*float64(l.Period)) - elapsed
+	} else {
+		res.RetryAfter = res.Reset
+	}
+	res.Remaining = int(math.Max(0, math.Floor(limit-estimate)))
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Config determines which limits apply to a request, and how they are tracked.
+type Config struct {
+	// Algorithm used for tracking limits, defaults to TokenBucket
+	Algorithm Algorithm
+	// Store for limiter state, defaults to a new MemoryStore
+	Store Store
+	// Limit applies to any principal without a more specific limit
+	Limit Limit
+	// AnonymousLimit applies to requests without a principal, keyed by client
+	// IP.  Defaults to Limit if not set.
+	AnonymousLimit Limit
+	// PrincipalLimits apply to specific principals, keyed by their
+	// `AuthenticationID`
+	PrincipalLimits map[string]Limit
+	// PermissionLimits apply to principals implementing `auth.Authorizer`.  The
+	// first permission granted to the principal determines the limit.
+	PermissionLimits []PermissionLimit
+	// ClientIP returns the address used for anonymous requests.  Defaults to
+	// `auth.RemoteIP`.
+	ClientIP func(*http.Request) string
+}
+
+// NewRateLimiter returns a middleware that limits requests per principal found in
+// the request context at the specified key.  Rate limit headers are set on every
+// response, and an `auth.ErrTooManyRequests` is passed to the error handler
+// once a client exceeds its limit.
+func NewRateLimiter(contextKey string, failFn auth.ErrorHandler, config Config) func(http.Handler) http.Handler {
+	config = config.withDefaults()
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if err := checkLimit(contextKey, config, rw, r); err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			handler.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// NewRateLimiterMiddleware returns a negroni-style middleware that limits requests
+// per principal found in the request context at the specified key.
+func NewRateLimiterMiddleware(contextKey string, failFn auth.ErrorHandler, config Config) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	config = config.withDefaults()
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if err := checkLimit(contextKey, config, rw, r); err != nil {
+			failFn(rw, r, err)
+			return
+		}
+		next(rw, r)
+	}
+}
+
+func (c Config) withDefaults() Config {
+	if c.Algorithm == nil {
+		c.Algorithm = TokenBucket
+	}
+	if c.Store == nil {
+		c.Store = NewMemoryStore()
+	}
+	if !c.AnonymousLimit.enabled() {
+		c.AnonymousLimit = c.Limit
+	}
+	if c.ClientIP == nil {
+		c.ClientIP = auth.RemoteIP
+	}
+	return c
+}
+
+func checkLimit(contextKey string, config Config, rw http.ResponseWriter, r *http.Request) error {
+	key, limit, err := config.limitFor(r.Context().Value(contextKey), r)
+	if err != nil {
+		return err
+	}
+	if !limit.enabled() {
+		return nil
+	}
+
+	var res Result
+	now := time.Now()
+	err = config.Store.Update(key, 2*limit.Period, func(state *State) {
+		res = config.Algorithm.Take(state, limit, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	h := rw.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	if !res.Allowed {
+		return auth.NewErrTooManyRequests(res.RetryAfter)
+	}
+	return nil
+}
+
+// limitFor determines the key and limit that apply to the principal
+func (c Config) limitFor(principal interface{}, r *http.Request) (string, Limit, error) {
+	client, ok := principal.(auth.Authenticator)
+	if !ok || client.AuthenticationID() == "" {
+		return "ip:" + c.ClientIP(r), c.AnonymousLimit, nil
+	}
+
+	id := client.AuthenticationID()
+	key := "principal:" + id
+	if limit, ok := c.PrincipalLimits[id]; ok {
+		return key, limit, nil
+	}
+	if authorizer, ok := principal.(auth.Authorizer); ok {
+		for _, pl := range c.PermissionLimits {
+			allowed, err := authorizer.HasPermission(pl.Permission)
+			if err != nil {
+				return "", Limit{}, err
+			}
+			if allowed {
+				return key, pl.Limit, nil
+			}
+		}
+	}
+	return key, c.Limit, nil
+}
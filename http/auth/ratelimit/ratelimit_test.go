@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func handler(res http.ResponseWriter, req *http.Request) {
+	res.WriteHeader(200)
+	res.Write([]byte("Hello world!"))
+}
+
+func TestTokenBucket(t *testing.T) {
+	var state State
+	limit := Limit{Requests: 2, Period: 2 * time.Second}
+	now := time.Now()
+
+	// full bucket allows a burst
+	res := TokenBucket.Take(&state, limit, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+	res = TokenBucket.Take(&state, limit, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 2*time.Second, res.Reset)
+
+	// empty bucket denies, until a token is refilled
+	res = TokenBucket.Take(&state, limit, now)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+	res = TokenBucket.Take(&state, limit, now.Add(time.Second))
+	require.True(t, res.Allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	var state State
+	limit := Limit{Requests: 2, Period: time.Minute}
+	start := time.Now().Truncate(time.Minute)
+
+	res := SlidingWindow.Take(&state, limit, start)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+	res = SlidingWindow.Take(&state, limit, start.Add(30*time.Second))
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 30*time.Second, res.Reset)
+	res = SlidingWindow.Take(&state, limit, start.Add(45*time.Second))
+	require.False(t, res.Allowed)
+	require.Equal(t, 15*time.Second, res.RetryAfter)
+
+	// halfway through the next window, the previous two requests count as one
+	res = SlidingWindow.Take(&state, limit, start.Add(90*time.Second))
+	require.True(t, res.Allowed)
+	res = SlidingWindow.Take(&state, limit, start.Add(90*time.Second))
+	require.False(t, res.Allowed)
+	require.Equal(t, 30*time.Second, res.RetryAfter)
+
+	// windows that don't follow each other start over
+	res = SlidingWindow.Take(&state, limit, start.Add(5*time.Minute))
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	h := NewRateLimiter("ApiClient", auth.StandardErrorHandler, Config{
+		Limit:            Limit{Requests: 2, Period: time.Minute},
+		AnonymousLimit:   Limit{Requests: 1, Period: time.Minute},
+		PrincipalLimits:  map[string]Limit{"unlimited": Limit{}},
+		PermissionLimits: []PermissionLimit{{"bulk", Limit{Requests: 3, Period: time.Minute}}},
+	})(http.HandlerFunc(handler))
+
+	call := func(client interface{}, remoteAddr string) *http.Response {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = remoteAddr
+		if client != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Result()
+	}
+
+	t.Run("anonymous clients limited by ip", func(t *testing.T) {
+		res := call(nil, "10.0.0.1:1234")
+		require.Equal(t, 200, res.StatusCode)
+		require.Equal(t, "1", res.Header.Get("RateLimit-Limit"))
+		require.Equal(t, "0", res.Header.Get("RateLimit-Remaining"))
+		require.Equal(t, "60", res.Header.Get("RateLimit-Reset"))
+
+		res = call(nil, "10.0.0.1:4321")
+		require.Equal(t, 429, res.StatusCode)
+		require.Equal(t, "60", res.Header.Get("Retry-After"))
+		out, _ := ioutil.ReadAll(res.Body)
+		require.Equal(t, "Too many requests", string(out))
+
+		res = call(nil, "10.0.0.2:1234")
+		require.Equal(t, 200, res.StatusCode)
+	})
+
+	t.Run("principals limited by id", func(t *testing.T) {
+		client := auth.NewBasicApiClient("client-1", nil)
+		require.Equal(t, 200, call(client, "10.0.0.1:1234").StatusCode)
+		require.Equal(t, 200, call(client, "10.0.0.1:1234").StatusCode)
+		require.Equal(t, 429, call(client, "10.0.0.3:1234").StatusCode)
+	})
+
+	t.Run("per permission limits", func(t *testing.T) {
+		client := auth.NewBasicApiClient("client-2", []string{"bulk"})
+		for i := 0; i < 3; i++ {
+			require.Equal(t, 200, call(client, "10.0.0.1:1234").StatusCode)
+		}
+		res := call(client, "10.0.0.1:1234")
+		require.Equal(t, 429, res.StatusCode)
+		require.Equal(t, "3", res.Header.Get("RateLimit-Limit"))
+	})
+
+	t.Run("per principal limits", func(t *testing.T) {
+		client := auth.NewBasicApiClient("unlimited", []string{"bulk"})
+		for i := 0; i < 5; i++ {
+			res := call(client, "10.0.0.1:1234")
+			require.Equal(t, 200, res.StatusCode)
+			require.Equal(t, "", res.Header.Get("RateLimit-Limit"))
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the limiter state tracked per key.  Which fields are used depends on
+// the Algorithm.
+type State struct {
+	// Tokens remaining in a token bucket
+	Tokens float64
+	// Count of requests in the current window
+	Count int
+	// PrevCount of requests in the previous window
+	PrevCount int
+	// Start is the time of the last refill for a token bucket, or the start of
+	// the current window for a sliding window
+	Start time.Time
+}
+
+// Store persists limiter state between requests.  Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// Update atomically applies fn to the state stored for key, starting with a
+	// zero State if none exists.  The state may be discarded once it has not
+	// been updated for the ttl.
+	Update(key string, ttl time.Duration, fn func(*State)) error
+}
+
+// MemoryStore is a Store which keeps state in memory, suitable for a single
+// instance of an app.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// sweepInterval determines how often expired entries are removed
+const sweepInterval = time.Minute
+
+// NewMemoryStore returns a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*memoryEntry{}, lastSweep: time.Now()}
+}
+
+// Update implements Store
+func (m *MemoryStore) Update(key string, ttl time.Duration, fn func(*State)) error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &memoryEntry{}
+		m.entries[key] = e
+	}
+	fn(&e.state)
+	e.expires = now.Add(ttl)
+	return nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,62 @@
+package auth
+
+import "net/http"
+
+// Requirement describes what an authorizer requires of the object in the request
+// context before a handler will execute.
+type Requirement struct {
+	// Authenticated is true if an authenticated object is required
+	Authenticated bool
+	// Permissions which must all be granted
+	Permissions []string
+	// TenantScoped is true if the object must belong to, or be granted
+	// permissions in, the tenant the request is for
+	TenantScoped bool
+}
+
+// merge combines the requirements of nested authorizers
+func (r Requirement) merge(other Requirement) Requirement {
+	out := Requirement{
+		Authenticated: r.Authenticated || other.Authenticated,
+		TenantScoped:  r.TenantScoped || other.TenantScoped,
+	}
+	for _, perms := range [][]string{r.Permissions, other.Permissions} {
+		for _, p := range perms {
+			if !contains(out.Permissions, p) {
+				out.Permissions = append(out.Permissions, p)
+			}
+		}
+	}
+	return out
+}
+
+// ProtectedHandler is implemented by the handlers returned from the authorizers in
+// this package, so that the requirements of a route can be inspected after it has
+// been registered.  Requirements of nested authorizers are combined.
+//
+// Handlers wrapped by other middlewares, or protected with the negroni-style
+// middlewares, can't be inspected.
+type ProtectedHandler interface {
+	http.Handler
+	Requirement() Requirement
+}
+
+// Protect marks a handler created by an authorizer with its requirement, combined
+// with the requirement of next if it's also protected.  handler should check the
+// requirement before calling next.  It's intended for authorizers defined outside
+// of this package, so that routes they protect can be inspected.
+func Protect(handler http.Handler, next http.Handler, req Requirement) http.Handler {
+	if inner, ok := next.(ProtectedHandler); ok {
+		req = req.merge(inner.Requirement())
+	}
+	return protectedHandler{handler, req}
+}
+
+type protectedHandler struct {
+	http.Handler
+	requirement Requirement
+}
+
+func (p protectedHandler) Requirement() Requirement {
+	return p.requirement
+}
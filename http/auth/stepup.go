@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+// SecondFactorAuthenticator is implemented by objects in the request context
+// which know when a second factor was last verified for the session.  A zero time
+// means no second factor has been verified.
+type SecondFactorAuthenticator interface {
+	Authenticator
+	SecondFactorAt() time.Time
+}
+
+// NewStepUpAuthorizer returns an authorization middleware that requires the object
+// in the request context at the specified key to have verified a second factor
+// within maxAge.  Otherwise it fails with `ErrStepUpRequired`, which the standard
+// error handler turns into a 401 challenge, so the client can verify a second
+// factor and retry.
+//
+// The returned handlers implement ProtectedHandler.
+func NewStepUpAuthorizer(keyname string, maxAge time.Duration, failFn ErrorHandler) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if err := checkSecondFactor(keyname, maxAge, r); err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			handler.ServeHTTP(rw, r)
+		}), handler, Requirement{Authenticated: true})
+	}
+}
+
+// NewStepUpAuthorizerMiddleware returns a negroni-style middleware requiring a
+// recently verified second factor.  See `NewStepUpAuthorizer` for details.
+func NewStepUpAuthorizerMiddleware(keyname string, maxAge time.Duration, failFn ErrorHandler) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if err := checkSecondFactor(keyname, maxAge, r); err != nil {
+			failFn(rw, r, err)
+			return
+		}
+		next(rw, r)
+	}
+}
+
+func checkSecondFactor(keyname string, maxAge time.Duration, r *http.Request) (err error) {
+	defer func() { notifyAuthorization(r, keyname, err) }()
+	obj := r.Context().Value(keyname)
+	if _, ok := obj.(Authenticator); !ok || isExpired(obj) {
+		return ErrAuthenticationRequired
+	}
+	client, ok := obj.(SecondFactorAuthenticator)
+	if !ok {
+		return NewErrStepUpRequired(maxAge)
+	}
+	verified := client.SecondFactorAt()
+	if verified.IsZero() || time.Since(verified) > maxAge {
+		return NewErrStepUpRequired(maxAge)
+	}
+	return nil
+}
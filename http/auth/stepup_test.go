@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type secondFactorClient struct {
+	BasicApiClient
+	verified time.Time
+}
+
+func (c secondFactorClient) SecondFactorAt() time.Time {
+	return c.verified
+}
+
+func TestNewStepUpAuthorizer(t *testing.T) {
+	h := NewStepUpAuthorizer("ApiClient", 5*time.Minute, StandardErrorHandler)(http.HandlerFunc(handler))
+	call := func(client interface{}) *http.Response {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if client != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Result()
+	}
+
+	client := NewBasicApiClient("user-1", nil)
+	require.Equal(t, 200, call(secondFactorClient{client, time.Now().Add(-time.Minute)}).StatusCode)
+	require.Equal(t, 401, call(nil).StatusCode)
+
+	for _, c := range []interface{}{
+		client,
+		secondFactorClient{client, time.Time{}},
+		secondFactorClient{client, time.Now().Add(-time.Hour)},
+	} {
+		res := call(c)
+		require.Equal(t, 401, res.StatusCode)
+		require.Equal(t, `Bearer error="insufficient_user_authentication", error_description="A second factor is required", max_age=300`, res.Header.Get("WWW-Authenticate"))
+	}
+
+	req, ok := h.(ProtectedHandler)
+	require.True(t, ok)
+	require.Equal(t, Requirement{Authenticated: true}, req.Requirement())
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TenantAuthenticator is implemented by objects in the request context which
+// belong to a specific tenant.
+type TenantAuthenticator interface {
+	Authenticator
+	TenantID() string
+}
+
+// TenantAuthorizer is implemented by objects whose permissions are granted per
+// tenant.  It is expected by the tenant permissions authorizer.
+type TenantAuthorizer interface {
+	HasTenantPermission(tenantID, perm string) (bool, error)
+}
+
+// TenantResolver determines which tenant a request is for.  An empty string means
+// the request isn't for any tenant.
+type TenantResolver func(r *http.Request) (string, error)
+
+// TenantFromHeader returns a TenantResolver which reads the tenant id from the
+// named request header.
+func TenantFromHeader(name string) TenantResolver {
+	return func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	}
+}
+
+// TenantFromSubdomain returns a TenantResolver which reads the tenant id from the
+// subdomain of the base domain the request was made to.  For example with a base
+// domain of `example.com`, requests to `acme.example.com` are for the tenant
+// `acme`.
+func TenantFromSubdomain(baseDomain string) TenantResolver {
+	suffix := "." + strings.ToLower(baseDomain)
+	return func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(host)
+		if !strings.HasSuffix(host, suffix) {
+			return "", nil
+		}
+		sub := strings.TrimSuffix(host, suffix)
+		if strings.Contains(sub, ".") {
+			return "", nil
+		}
+		return sub, nil
+	}
+}
+
+// TenantFromPath returns a TenantResolver which reads the tenant id from the path
+// segment following the prefix.  For example with a prefix of `/tenants/`,
+// requests to `/tenants/acme/users` are for the tenant `acme`.
+func TenantFromPath(prefix string) TenantResolver {
+	return func(r *http.Request) (string, error) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return "", nil
+		}
+		return strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)[0], nil
+	}
+}
+
+// NewTenantAuthorizer returns an authorization middleware that requires a
+// TenantAuthenticator be set in the request context at the specified key, which
+// belongs to the tenant the request is for.  Cross-tenant requests, and requests
+// which aren't for any tenant, fail with ErrAuthorizationFailed.
+func NewTenantAuthorizer(keyname string, resolver TenantResolver, failFn ErrorHandler) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			_, err := checkTenant(keyname, resolver, r)
+			if err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			handler.ServeHTTP(rw, r)
+		}), handler, Requirement{Authenticated: true, TenantScoped: true})
+	}
+}
+
+// NewTenantAuthorizerMiddleware returns a negroni-style authorization middleware
+// that requires a TenantAuthenticator be set in the request context at the
+// specified key, which belongs to the tenant the request is for.
+func NewTenantAuthorizerMiddleware(keyname string, resolver TenantResolver, failFn ErrorHandler) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		_, err := checkTenant(keyname, resolver, r)
+		if err != nil {
+			failFn(rw, r, err)
+			return
+		}
+		next(rw, r)
+	}
+}
+
+func checkTenant(keyname string, resolver TenantResolver, req *http.Request) (_ bool, err error) {
+	defer func() { notifyAuthorization(req, keyname, err) }()
+	c := req.Context().Value(keyname)
+	client, ok := c.(TenantAuthenticator)
+	if !ok || isExpired(c) {
+		return false, ErrAuthenticationRequired
+	}
+	tenant, err := resolver(req)
+	if err != nil {
+		return false, err
+	}
+	if tenant == "" || client.TenantID() != tenant {
+		return false, ErrAuthorizationFailed
+	}
+	return true, nil
+}
+
+// NewTenantPermissionsAuthorizer returns an authorization middleware that requires a
+// TenantAuthorizer be set in the request context at the specified key.  Handlers
+// wrapped by it will only execute if the TenantAuthorizer grants all specified
+// permissions in the tenant the request is for.
+func NewTenantPermissionsAuthorizer(keyname string, resolver TenantResolver, failFn ErrorHandler) func(http.Handler, ...string) http.Handler {
+	return func(handler http.Handler, perms ...string) http.Handler {
+		return Protect(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			_, err := checkTenantPermissions(keyname, resolver, r, perms...)
+			if err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			handler.ServeHTTP(rw, r)
+		}), handler, Requirement{Authenticated: true, Permissions: perms, TenantScoped: true})
+	}
+}
+
+// NewTenantPermissionsAuthorizerMiddleware returns a negroni-style middleware
+// factory for invoking per tenant permission checks
+func NewTenantPermissionsAuthorizerMiddleware(keyname string, resolver TenantResolver, failFn ErrorHandler) func(...string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+	return func(perms ...string) func(http.ResponseWriter, *http.Request, http.HandlerFunc) {
+		return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+			_, err := checkTenantPermissions(keyname, resolver, r, perms...)
+			if err != nil {
+				failFn(rw, r, err)
+				return
+			}
+			next(rw, r)
+		}
+	}
+}
+
+func checkTenantPermissions(keyname string, resolver TenantResolver, req *http.Request, perms ...string) (_ bool, err error) {
+	defer func() { notifyAuthorization(req, keyname, err) }()
+	a := req.Context().Value(keyname)
+	authorizer, ok := a.(TenantAuthorizer)
+	if !ok || isExpired(a) {
+		return false, ErrAuthenticationRequired
+	}
+	tenant, err := resolver(req)
+	if err != nil {
+		return false, err
+	}
+	if tenant == "" {
+		return false, ErrAuthorizationFailed
+	}
+
+	for _, perm := range perms {
+		if allowed, err := authorizer.HasTenantPermission(tenant, perm); err != nil {
+			return false, err
+		} else if !allowed {
+			return false, ErrPermissionDenied{perm}
+		}
+	}
+	return true, nil
+}
+
+// NewBasicTenantClient returns a new BasicTenantClient with the specified id, home
+// tenant, and permissions granted per tenant id.
+func NewBasicTenantClient(id, tenantID string, grants map[string][]string) BasicTenantClient {
+	return BasicTenantClient{id, tenantID, grants}
+}
+
+// BasicTenantClient implements the TenantAuthenticator, TenantAuthorizer,
+// Authorizer and PermissionLister interfaces.  Checks via the Authorizer
+// interface, and listed permissions, are evaluated against its home tenant.
+type BasicTenantClient struct {
+	id       string
+	tenantID string
+	grants   map[string][]string
+}
+
+func (b BasicTenantClient) AuthenticationID() string {
+	return b.id
+}
+
+func (b BasicTenantClient) TenantID() string {
+	return b.tenantID
+}
+
+func (b BasicTenantClient) HasTenantPermission(tenantID, perm string) (bool, error) {
+	return contains(b.grants[tenantID], perm), nil
+}
+
+func (b BasicTenantClient) HasPermission(perm string) (bool, error) {
+	return b.HasTenantPermission(b.tenantID, perm)
+}
+
+func (b BasicTenantClient) Permissions() []string {
+	return b.grants[b.tenantID]
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantResolvers(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://acme.example.com:8080/tenants/globex/users", nil)
+	r.Header.Set("X-Tenant", "initech")
+
+	tests := []struct {
+		resolver TenantResolver
+		expected string
+	}{
+		{TenantFromHeader("X-Tenant"), "initech"},
+		{TenantFromHeader("X-Other"), ""},
+		{TenantFromSubdomain("example.com"), "acme"},
+		{TenantFromSubdomain("acme.example.com"), ""},
+		{TenantFromSubdomain("example.org"), ""},
+		{TenantFromPath("/tenants/"), "globex"},
+		{TenantFromPath("/orgs/"), ""},
+	}
+	for _, test := range tests {
+		tenant, err := test.resolver(r)
+		require.Nil(t, err)
+		require.Equal(t, test.expected, tenant)
+	}
+}
+
+func TestNewTenantAuthorizer(t *testing.T) {
+	h := NewTenantAuthorizer("ApiClient", TenantFromHeader("X-Tenant"), StandardErrorHandler)(http.HandlerFunc(handler))
+	call := func(client interface{}, tenant string) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("X-Tenant", tenant)
+		if client != nil {
+			r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Result().StatusCode
+	}
+
+	client := NewBasicTenantClient("user-1", "acme", nil)
+	require.Equal(t, 200, call(client, "acme"))
+	require.Equal(t, 403, call(client, "globex"))
+	require.Equal(t, 403, call(client, ""))
+	require.Equal(t, 401, call(nil, "acme"))
+	require.Equal(t, 401, call(NewBasicApiClient("user-1", nil), "acme"))
+}
+
+func TestNewTenantPermissionsAuthorizer(t *testing.T) {
+	authPerms := NewTenantPermissionsAuthorizer("ApiClient", TenantFromPath("/tenants/"), StandardErrorHandler)
+	h := authPerms(http.HandlerFunc(handler), "users.read")
+	call := func(client interface{}, path string) int {
+		r := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), "ApiClient", client))
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Result().StatusCode
+	}
+
+	// a consultant with access to users in two tenants, but only writes in one
+	client := NewBasicTenantClient("consultant-1", "acme", map[string][]string{
+		"acme":   {"users.read", "users.write"},
+		"globex": {"users.read"},
+	})
+	require.Equal(t, 200, call(client, "/tenants/acme/users"))
+	require.Equal(t, 200, call(client, "/tenants/globex/users"))
+	require.Equal(t, 403, call(client, "/tenants/initech/users"))
+	require.Equal(t, 403, call(client, "/users"))
+
+	// the Authorizer interface checks the home tenant
+	allowed, _ := client.HasPermission("users.write")
+	require.True(t, allowed)
+	allowed, _ = client.HasTenantPermission("globex", "users.write")
+	require.False(t, allowed)
+}
@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+// APIKey is an entry in an api key allowlist
+type APIKey struct {
+	ID          string   `json:"id"`
+	Permissions []string `json:"permissions"`
+}
+
+// APIKeys is an api key allowlist, keyed by the hex encoded SHA-256 of each key,
+// so the keys themselves aren't stored in the file:
+//
+//	{"9f86d08...": {"id": "billing-service", "permissions": ["invoices.read"]}}
+type APIKeys map[string]APIKey
+
+// HashAPIKey returns the hash an api key is listed by
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKeys is a Parser for JSON api key allowlists.  Entries must be listed by
+// lowercase hex hashes, as returned by HashAPIKey.
+func ParseAPIKeys(data []byte) (interface{}, error) {
+	var keys APIKeys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	for hash, key := range keys {
+		if !validHash(hash) || key.ID == "" {
+			return nil, &InvalidEntryError{hash}
+		}
+	}
+	return keys, nil
+}
+
+// validHash returns whether an allowlist entry is listed by a hash in the format
+// returned by HashAPIKey.  Anything else could never match a key, so a typo would
+// silently revoke it.
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 || strings.ToLower(hash) != hash {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// InvalidEntryError is returned when parsing an allowlist or role definitions with
+// an invalid entry
+type InvalidEntryError struct {
+	key string
+}
+
+// Key returns the key of the invalid entry
+func (e *InvalidEntryError) Key() string {
+	return e.key
+}
+
+func (e *InvalidEntryError) Error() string {
+	return "watch: invalid entry " + e.key
+}
+
+// APIKeyAuthenticator returns an authenticator function, which can be used as an
+// `apikeyauth.APIKeyAuthenticator`, checking keys against the current allowlist in
+// a source parsed with ParseAPIKeys.  Listed keys authenticate as an
+// `auth.BasicApiClient`.
+func APIKeyAuthenticator(s *Source) func(key string) (interface{}, error) {
+	return func(key string) (interface{}, error) {
+		keys, _ := s.Value().(APIKeys)
+		entry, ok := keys[HashAPIKey(key)]
+		if !ok {
+			return nil, auth.ErrAuthenticationRequired
+		}
+		return auth.NewBasicApiClient(entry.ID, entry.Permissions), nil
+	}
+}
+
+// Roles are role definitions, the permissions granted by each role:
+//
+//	{"viewer": ["users.read"], "admin": ["users.read", "users.write"]}
+type Roles map[string][]string
+
+// ParseRoles is a Parser for JSON role definitions
+func ParseRoles(data []byte) (interface{}, error) {
+	var roles Roles
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+	for name := range roles {
+		if name == "" {
+			return nil, &InvalidEntryError{name}
+		}
+	}
+	return roles, nil
+}
+
+// Permissions returns the sorted permissions granted by any of the roles.  Unknown
+// roles grant nothing.
+func (r Roles) Permissions(roles ...string) []string {
+	seen := map[string]bool{}
+	perms := []string{}
+	for _, role := range roles {
+		for _, perm := range r[role] {
+			if !seen[perm] {
+				seen[perm] = true
+				perms = append(perms, perm)
+			}
+		}
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+// CurrentRoles returns the current role definitions of a source parsed with
+// ParseRoles
+func CurrentRoles(s *Source) Roles {
+	roles, _ := s.Value().(Roles)
+	return roles
+}
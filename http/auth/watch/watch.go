@@ -0,0 +1,158 @@
+// Package watch reloads credentials and policies while a service is running, such
+// as api key allowlists, role definitions and route policy files.  A Source
+// fetches raw data from a file or a callback, validates it by parsing it, and
+// atomically swaps in the parsed value.  Invalid data is reported and the last
+// good value is kept, so a bad deploy of a config file can't lock everyone out.
+//
+// Files are polled rather than watched with filesystem notifications, and only
+// reparsed when their checksum changes, so it works the same everywhere,
+// including with files mounted from config maps.
+package watch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fetcher returns the raw data of a source
+type Fetcher func() ([]byte, error)
+
+// Parser validates raw data, returning the value to swap in.  If an error is
+// returned, the previous value is kept.
+type Parser func(data []byte) (interface{}, error)
+
+// Event reports the outcome of a reload which either changed the value or failed.
+// Reloads finding the data unchanged aren't reported.
+type Event struct {
+	// Source is the name of the source, e.g. the file path
+	Source string
+	Time   time.Time
+	// Checksum is the SHA-256 of the data fetched, empty if fetching failed
+	Checksum string
+	// Changed is true if a new value was swapped in
+	Changed bool
+	// Value is the current value, which is the previous value if the reload
+	// failed
+	Value interface{}
+	Err   error
+}
+
+// Source holds the latest valid value parsed from its data
+type Source struct {
+	// OnReload is called with every reported event.  It must be set before the
+	// source is loaded or watched.
+	OnReload func(Event)
+
+	name  string
+	fetch Fetcher
+	parse Parser
+
+	mu       sync.Mutex // serializes reloads
+	checksum string
+	// failed is the checksum of the last invalid data, so it's only reported
+	// once
+	failed    string
+	failedErr error
+	// fetchErr is the message of the last fetch error, so that an outage is
+	// only reported once
+	fetchErr string
+	value    atomic.Value
+}
+
+// NewFile creates a source reading the file at path
+func NewFile(path string, parse Parser) *Source {
+	return NewFunc(path, func() ([]byte, error) { return ioutil.ReadFile(path) }, parse)
+}
+
+// NewFunc creates a source fetching data from a callback, e.g. to load it from a
+// database.  The name identifies the source in events.
+func NewFunc(name string, fetch Fetcher, parse Parser) *Source {
+	return &Source{name: name, fetch: fetch, parse: parse}
+}
+
+// Value returns the current value, or nil if none has loaded successfully
+func (s *Source) Value() interface{} {
+	v, _ := s.value.Load().(*valueBox)
+	if v == nil {
+		return nil
+	}
+	return v.val
+}
+
+// valueBox lets atomic.Value hold values of differing types
+type valueBox struct {
+	val interface{}
+}
+
+// Reload fetches the data, and swaps in the parsed value if the data has changed
+// and is valid.  Errors are returned as well as reported, so services can refuse
+// to start with an invalid initial value.  Invalid data is only reported the first
+// time it's fetched, and fetch errors are only reported until the error changes
+// or a fetch succeeds.
+func (s *Source) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ev := Event{Source: s.name, Time: time.Now()}
+	data, err := s.fetch()
+	if err != nil {
+		if err.Error() == s.fetchErr {
+			return err
+		}
+		s.fetchErr = err.Error()
+		return s.report(ev, err)
+	}
+	s.fetchErr = ""
+	sum := sha256.Sum256(data)
+	ev.Checksum = hex.EncodeToString(sum[:])
+	if ev.Checksum == s.checksum {
+		return nil
+	}
+	if ev.Checksum == s.failed {
+		return s.failedErr
+	}
+
+	val, err := s.parse(data)
+	if err != nil {
+		s.failed, s.failedErr = ev.Checksum, err
+		return s.report(ev, err)
+	}
+	s.value.Store(&valueBox{val})
+	s.checksum, s.failed, s.failedErr = ev.Checksum, "", nil
+	ev.Changed = true
+	return s.report(ev, nil)
+}
+
+func (s *Source) report(ev Event, err error) error {
+	ev.Err = err
+	ev.Value = s.Value()
+	if s.OnReload != nil {
+		s.OnReload(ev)
+	}
+	return err
+}
+
+// Watch reloads the source at the interval until the returned function is
+// called.  It doesn't load the source first, so Reload should generally be
+// called before watching.
+func (s *Source) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
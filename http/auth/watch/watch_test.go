@@ -0,0 +1,157 @@
+package watch
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+)
+
+func TestReload(t *testing.T) {
+	data := []byte(`{"viewer": ["users.read"]}`)
+	var fetchErr error
+	var events []Event
+	s := NewFunc("roles", func() ([]byte, error) { return data, fetchErr }, ParseRoles)
+	s.OnReload = func(ev Event) { events = append(events, ev) }
+	require.Nil(t, s.Value())
+
+	require.Nil(t, s.Reload())
+	require.Equal(t, []string{"users.read"}, CurrentRoles(s).Permissions("viewer"))
+	require.Len(t, events, 1)
+	require.True(t, events[0].Changed)
+	require.Equal(t, "roles", events[0].Source)
+	require.Equal(t, HashAPIKey(string(data)), events[0].Checksum)
+
+	// unchanged data isn't reparsed or reported
+	require.Nil(t, s.Reload())
+	require.Len(t, events, 1)
+
+	// invalid data is reported once, and the last good value is kept
+	data = []byte(`{"viewer": `)
+	require.NotNil(t, s.Reload())
+	require.NotNil(t, s.Reload())
+	require.Len(t, events, 2)
+	require.False(t, events[1].Changed)
+	require.NotNil(t, events[1].Err)
+	require.Equal(t, CurrentRoles(s), events[1].Value)
+	require.Equal(t, []string{"users.read"}, CurrentRoles(s).Permissions("viewer"))
+
+	// fetch errors are reported once, until they change or a fetch succeeds
+	fetchErr = errors.New("database unavailable")
+	require.Equal(t, fetchErr, s.Reload())
+	require.Equal(t, fetchErr, s.Reload())
+	require.Len(t, events, 3)
+	require.Equal(t, "", events[2].Checksum)
+	fetchErr = errors.New("database timeout")
+	require.Equal(t, fetchErr, s.Reload())
+	require.Len(t, events, 4)
+
+	fetchErr = nil
+	data = []byte(`{"viewer": ["users.read"], "admin": ["users.read", "users.write"]}`)
+	require.Nil(t, s.Reload())
+	require.Equal(t, []string{"users.read", "users.write"}, CurrentRoles(s).Permissions("viewer", "admin", "unknown"))
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	hash := HashAPIKey("key-1")
+	_, err := ParseAPIKeys([]byte(`{"` + hash + `": {"id": "service-1"}}`))
+	require.Nil(t, err)
+
+	// entries which could never match a key are refused
+	for _, bad := range []string{
+		"key-1",
+		strings.ToUpper(hash),
+		"zz" + hash[2:],
+		hash[1:],
+	} {
+		_, err := ParseAPIKeys([]byte(`{"` + bad + `": {"id": "service-1"}}`))
+		require.IsType(t, &InvalidEntryError{}, err, bad)
+		require.Equal(t, bad, err.(*InvalidEntryError).Key())
+	}
+	_, err = ParseAPIKeys([]byte(`{"` + hash + `": {"permissions": ["users.read"]}}`))
+	require.IsType(t, &InvalidEntryError{}, err)
+}
+
+func TestWatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	write := func(keys APIKeys) {
+		data := []byte("{")
+		for hash, key := range keys {
+			data = append(data, []byte(`"`+hash+`": {"id": "`+key.ID+`", "permissions": ["users.read"]}`)...)
+		}
+		data = append(data, '}')
+		// replaced atomically, so the watcher never reads a partial file
+		require.Nil(t, ioutil.WriteFile(path+".tmp", data, 0644))
+		require.Nil(t, os.Rename(path+".tmp", path))
+	}
+	write(APIKeys{HashAPIKey("key-1"): {ID: "service-1"}})
+
+	var mu sync.Mutex
+	var events []Event
+	s := NewFile(path, ParseAPIKeys)
+	s.OnReload = func(ev Event) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}
+	require.Nil(t, s.Reload())
+	stop := s.Watch(5 * time.Millisecond)
+	defer stop()
+
+	authenticate := apikeyauth.NewAPIKeyAuthenticator("Key", "ApiClient", auth.StandardErrorHandler, APIKeyAuthenticator(s))
+	h := authenticate(auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})))
+	call := func(key string) int {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.Header.Set("Authorization", "Key "+key)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Code
+	}
+	require.Equal(t, 200, call("key-1"))
+	require.Equal(t, 401, call("key-2"))
+
+	// revoking key-1 and adding key-2 takes effect without a restart
+	write(APIKeys{HashAPIKey("key-2"): {ID: "service-2"}})
+	deadline := time.Now().Add(2 * time.Second)
+	for call("key-2") != 200 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.Equal(t, 401, call("key-1"))
+
+	// entries must be listed by hash
+	write(APIKeys{"key-3": {ID: "service-3"}})
+	deadline = time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		last := events[len(events)-1]
+		mu.Unlock()
+		if last.Err != nil {
+			_, ok := last.Err.(*InvalidEntryError)
+			require.True(t, ok)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.Equal(t, 200, call("key-2"))
+}
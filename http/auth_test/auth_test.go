@@ -13,6 +13,8 @@ import (
 
 	"github.com/globalprofessionalsearch/go-tools/http/auth"
 	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+	"github.com/globalprofessionalsearch/go-tools/testing/oauthtest"
 	"github.com/globalprofessionalsearch/go-tools/testing/webtest"
 )
 
@@ -29,8 +31,9 @@ var (
 // authenticated user actually have certain permissions.
 //
 // As new forms of auth are supported by the `auth` package, this test should be
-// updated to test a realistic usage example
-func appRouter() http.Handler {
+// updated to test a realistic usage example.  Any additional authenticators, such
+// as for JWT tokens, wrap the router along with the api key authenticator.
+func appRouter(authenticators ...func(http.Handler) http.Handler) http.Handler {
 	// create the authenticators and authorizers
 	apikeyAuthenticator := apikeyauth.NewAPIKeyAuthenticator("Key", "ApiClient", auth.StandardErrorHandler, appAuthenticateAPIKey)
 	authClient := auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)
@@ -47,6 +50,9 @@ func appRouter() http.Handler {
 	// create the main app handler by wrapping the router
 	// in the various authenticator middlewares
 	handler := apikeyAuthenticator(router)
+	for _, authenticate := range authenticators {
+		handler = authenticate(handler)
+	}
 
 	n := negroni.New()
 	n.UseHandler(handler)
@@ -174,6 +180,57 @@ func TestAppApikeyAuth(t *testing.T) {
 }
 
 func TestAppJWTAuth(t *testing.T) {
-	// TODO: test w/ variety of good & bad JWT tokens
-	t.Skip("Not implemented...")
+	provider := oauthtest.NewProvider(t)
+	defer provider.Close()
+	verifier := &jwtauth.Verifier{
+		KeyFunc:  jwtauth.NewKeySet(provider.KeysURL()).KeyFunc,
+		Issuer:   provider.Issuer(),
+		Audience: "my-app",
+	}
+	jwtAuthenticator := jwtauth.NewJWTAuthenticator("Bearer", "ApiClient", auth.StandardErrorHandler, verifier, jwtauth.PermissionsAuthenticator)
+	ts := httptest.NewServer(appRouter(jwtAuthenticator))
+	defer ts.Close()
+
+	fullPerms := oauthtest.Claims{"sub": "user-1", "aud": "my-app", "perms": []string{"users.read", "users.write"}}
+	partialPerms := oauthtest.Claims{"sub": "user-2", "aud": "my-app", "perms": []string{"users.read"}}
+	tests := []struct {
+		name, method, path, token string
+		code                      int
+		text                      string
+	}{
+		// good token, full permissions
+		{"full perms", "GET", "/public", provider.Token(fullPerms), 200, "Hello user-1"},
+		{"full perms", "GET", "/private", provider.Token(fullPerms), 200, "Hello user-1"},
+		{"full perms", "GET", "/private/users", provider.Token(fullPerms), 200, "Hello user-1"},
+		{"full perms", "POST", "/private/users", provider.Token(fullPerms), 200, "Hello user-1"},
+
+		// good token, partial permissions
+		{"partial perms", "GET", "/private/users", provider.Token(partialPerms), 200, "Hello user-2"},
+		{"partial perms", "POST", "/private/users", provider.Token(partialPerms), 403, "Access denied"},
+
+		// bad tokens, should all fail
+		{"expired", "GET", "/private", provider.ExpiredToken(fullPerms), 401, "Authentication required"},
+		{"bad signature", "GET", "/private", provider.BadlySignedToken(fullPerms), 401, "Authentication required"},
+		{"wrong audience", "GET", "/private", provider.Token(oauthtest.Claims{"sub": "user-1", "aud": "other-app"}), 401, "Authentication required"},
+		{"wrong issuer", "GET", "/public", provider.Token(oauthtest.Claims{"sub": "user-1", "aud": "my-app", "iss": "https://evil.example.com"}), 401, "Authentication required"},
+		{"malformed", "GET", "/public", "not-a-token", 401, "Authentication required"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name+" "+test.method+" "+test.path, func(t *testing.T) {
+			client := webtest.NewClient(t).SetTargetServer(ts)
+			req := client.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			res := client.Do(req)
+			require.Equal(t, test.code, res.StatusCode)
+			out, _ := ioutil.ReadAll(res.Body)
+			require.Equal(t, test.text, string(out))
+		})
+	}
+
+	// the provider can also set up clients which send a token automatically
+	client := provider.Client(t, ts, partialPerms)
+	res := client.Call("GET", "/private/users", nil)
+	require.Equal(t, 200, res.StatusCode)
 }
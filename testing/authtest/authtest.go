@@ -0,0 +1,188 @@
+// Package authtest provides helpers for testing apps using the `http/auth`
+// packages: requests carrying principals, a fake authenticator, an error handler
+// which records auth errors, and assertions on denied responses.
+//
+// Example:
+//
+//	errs := authtest.NewErrorRecorder()
+//	authPerms := auth.NewPermissionsAuthorizer("ApiClient", errs.Handle)
+//	ts := httptest.NewServer(authPerms(handler, "users.write"))
+//	...
+//	res := client.Call("POST", "/users", nil)
+//	authtest.RequireDenied(t, res, "users.write")
+package authtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+)
+
+// ErrorHeader is the response header the ErrorRecorder sets to the auth error,
+// so assertions can tell why a request failed.
+const ErrorHeader = "X-Authtest-Error"
+
+// WithPrincipal returns a copy of the request carrying the principal in its
+// context at the key, as if it had been authenticated.
+func WithPrincipal(r *http.Request, contextKey string, principal interface{}) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKey, principal))
+}
+
+// NewRequest returns a new incoming server request, as with `httptest.NewRequest`,
+// carrying the principal in its context at the key.  A nil principal results in an
+// unauthenticated request.
+func NewRequest(method, target, contextKey string, principal interface{}) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if principal == nil {
+		return r
+	}
+	return WithPrincipal(r, contextKey, principal)
+}
+
+// Authenticator is a fake authenticator, authenticating tokens registered with
+// `Add`.  Its Authenticate method can be used as an `apikeyauth.APIKeyAuthenticator`.
+type Authenticator struct {
+	mu         sync.Mutex
+	principals map[string]interface{}
+	calls      []string
+}
+
+// NewAuthenticator returns an Authenticator without any tokens
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{principals: map[string]interface{}{}}
+}
+
+// Add registers a token, which authenticates as the principal
+func (a *Authenticator) Add(token string, principal interface{}) *Authenticator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.principals[token] = principal
+	return a
+}
+
+// Remove unregisters a token, e.g. to simulate revocation
+func (a *Authenticator) Remove(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.principals, token)
+}
+
+// Authenticate returns the principal registered for the token, or
+// `auth.ErrAuthenticationRequired`
+func (a *Authenticator) Authenticate(token string) (interface{}, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, token)
+	if principal, ok := a.principals[token]; ok {
+		return principal, nil
+	}
+	return nil, auth.ErrAuthenticationRequired
+}
+
+// Calls returns every token Authenticate was called with, in order
+func (a *Authenticator) Calls() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.calls...)
+}
+
+// ErrorRecorder is an `auth.ErrorHandler` which records errors before handing
+// them to another error handler, and sets ErrorHeader on the response.
+type ErrorRecorder struct {
+	// Next handles errors after they're recorded, defaults to
+	// `auth.StandardErrorHandler`
+	Next auth.ErrorHandler
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// NewErrorRecorder returns an ErrorRecorder using the standard error handler
+func NewErrorRecorder() *ErrorRecorder {
+	return &ErrorRecorder{Next: auth.StandardErrorHandler}
+}
+
+// Handle records the error, and is used as the error handler of auth middlewares
+func (e *ErrorRecorder) Handle(rw http.ResponseWriter, r *http.Request, err error) {
+	e.mu.Lock()
+	e.errs = append(e.errs, err)
+	e.mu.Unlock()
+
+	rw.Header().Set(ErrorHeader, err.Error())
+	next := e.Next
+	if next == nil {
+		next = auth.StandardErrorHandler
+	}
+	next(rw, r, err)
+}
+
+// Errors returns every recorded error, in order
+func (e *ErrorRecorder) Errors() []error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]error(nil), e.errs...)
+}
+
+// Last returns the most recently recorded error, or nil
+func (e *ErrorRecorder) Last() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e.errs[len(e.errs)-1]
+}
+
+// Reset forgets recorded errors
+func (e *ErrorRecorder) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = nil
+}
+
+// RequireAllowed fails the test unless the response has a 2xx status
+func RequireAllowed(t *testing.T, res *http.Response) {
+	t.Helper()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.Fatalf("expected request to be allowed, got %d%s", res.StatusCode, reason(res))
+	}
+}
+
+// RequireUnauthenticated fails the test unless the response has a 401 status
+func RequireUnauthenticated(t *testing.T, res *http.Response) {
+	t.Helper()
+	if res.StatusCode != 401 {
+		t.Fatalf("expected 401, got %d%s", res.StatusCode, reason(res))
+	}
+}
+
+// RequireDenied fails the test unless the response has a 403 status.  If perm is
+// given, the response must also have been denied for lacking that permission,
+// which requires the authorizer to use an ErrorRecorder.
+func RequireDenied(t *testing.T, res *http.Response, perm string) {
+	t.Helper()
+	if res.StatusCode != 403 {
+		t.Fatalf("expected 403, got %d%s", res.StatusCode, reason(res))
+	}
+	if perm == "" {
+		return
+	}
+	header := res.Header.Get(ErrorHeader)
+	if header == "" {
+		t.Fatalf("can't check the permission %q was denied, as the response has no %s header; use an ErrorRecorder as the authorizer's error handler", perm, ErrorHeader)
+	}
+	if expected := auth.NewErrPermissionDenied(perm).Error(); header != expected {
+		t.Fatalf("expected %q, got %q", expected, header)
+	}
+}
+
+func reason(res *http.Response) string {
+	if header := res.Header.Get(ErrorHeader); header != "" {
+		return " (" + header + ")"
+	}
+	return ""
+}
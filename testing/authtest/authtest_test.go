@@ -0,0 +1,64 @@
+package authtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+	"github.com/globalprofessionalsearch/go-tools/testing/webtest"
+)
+
+func appHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Write([]byte("Hello world!"))
+}
+
+func TestNewRequest(t *testing.T) {
+	errs := NewErrorRecorder()
+	h := auth.NewPermissionsAuthorizer("ApiClient", errs.Handle)(http.HandlerFunc(appHandler), "users.write")
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, NewRequest("POST", "/users", "ApiClient", auth.NewBasicApiClient("writer", []string{"users.write"})))
+	RequireAllowed(t, rw.Result())
+	require.Nil(t, errs.Last())
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, NewRequest("POST", "/users", "ApiClient", auth.NewBasicApiClient("reader", nil)))
+	RequireDenied(t, rw.Result(), "users.write")
+	require.Equal(t, auth.NewErrPermissionDenied("users.write"), errs.Last())
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, NewRequest("POST", "/users", "ApiClient", nil))
+	RequireUnauthenticated(t, rw.Result())
+	require.Equal(t, []error{auth.NewErrPermissionDenied("users.write"), auth.ErrAuthenticationRequired}, errs.Errors())
+
+	errs.Reset()
+	require.Len(t, errs.Errors(), 0)
+}
+
+func TestAuthenticator(t *testing.T) {
+	authn := NewAuthenticator().
+		Add("reader-token", auth.NewBasicApiClient("reader", []string{"users.read"})).
+		Add("writer-token", auth.NewBasicApiClient("writer", []string{"users.read", "users.write"}))
+	errs := NewErrorRecorder()
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", errs.Handle)
+	authenticate := apikeyauth.NewAPIKeyAuthenticator("Bearer", "ApiClient", errs.Handle, authn.Authenticate)
+	ts := httptest.NewServer(authenticate(authPerms(http.HandlerFunc(appHandler), "users.write")))
+	defer ts.Close()
+
+	call := func(token string) *http.Response {
+		client := webtest.NewClient(t).SetTargetServer(ts)
+		client.SetDefaultHeaders(map[string]string{"Authorization": "Bearer " + token})
+		return client.Call("POST", "/users", nil)
+	}
+	RequireAllowed(t, call("writer-token"))
+	RequireDenied(t, call("reader-token"), "users.write")
+	RequireUnauthenticated(t, call("unknown-token"))
+
+	authn.Remove("writer-token")
+	RequireUnauthenticated(t, call("writer-token"))
+	require.Equal(t, []string{"writer-token", "reader-token", "unknown-token", "writer-token"}, authn.Calls())
+}
@@ -0,0 +1,278 @@
+package authtest
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"unicode"
+
+	"github.com/globalprofessionalsearch/go-tools/testing/webtest"
+)
+
+var update = flag.Bool("authtest.update", false, "write the current permission matrices to their golden files")
+
+// Outcome is the outcome of a request in a permission matrix
+type Outcome string
+
+const (
+	// Allow is any 2xx response.  Redirects aren't followed, and are reported
+	// by their status, e.g. `302` for apps redirecting anonymous requests to a
+	// login page.
+	Allow Outcome = "allow"
+	// Unauthenticated is a 401 response
+	Unauthenticated Outcome = "401"
+	// Denied is a 403 response
+	Denied Outcome = "403"
+)
+
+// unknown is written in place of outcomes which aren't expected
+const unknown Outcome = "?"
+
+func outcome(status int) Outcome {
+	if status >= 200 && status < 300 {
+		return Allow
+	}
+	return Outcome(strconv.Itoa(status))
+}
+
+// Principal is a named column of a permission matrix, sending the Authorization
+// header when making requests.  An empty header makes anonymous requests.  Names
+// must be non-empty and can't contain whitespace, so that they can be written to
+// golden files.
+type Principal struct {
+	Name          string
+	Authorization string
+}
+
+// Matrix is the expected outcome of every route, as `METHOD /path`, for every
+// principal.
+//
+// Example:
+//
+//	authtest.Matrix{
+//		Principals: []authtest.Principal{{"anonymous", ""}, {"reader", "Key reader-key"}},
+//		Routes:     []string{"GET /users", "POST /users"},
+//		Expected: map[string]map[string]authtest.Outcome{
+//			"GET /users":  {"anonymous": authtest.Unauthenticated, "reader": authtest.Allow},
+//			"POST /users": {"anonymous": authtest.Unauthenticated, "reader": authtest.Denied},
+//		},
+//	}.Run(t, handler)
+type Matrix struct {
+	Principals []Principal
+	// Routes in the order they're reported, defaults to the sorted routes in
+	// Expected
+	Routes   []string
+	Expected map[string]map[string]Outcome
+}
+
+func (m Matrix) routes() []string {
+	if m.Routes != nil {
+		return m.Routes
+	}
+	routes := make([]string, 0, len(m.Expected))
+	for route := range m.Expected {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// validate checks that the principals can be written to golden files
+func (m Matrix) validate() error {
+	for _, p := range m.Principals {
+		if p.Name == "" || strings.IndexFunc(p.Name, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("invalid principal name %q, names can't be empty or contain whitespace", p.Name)
+		}
+	}
+	return nil
+}
+
+// Actual makes a request for every route and principal to the handler, returning
+// the matrix of outcomes.
+func (m Matrix) Actual(t *testing.T, handler http.Handler) Matrix {
+	t.Helper()
+	if err := m.validate(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	client := webtest.NewClient(t).SetTargetServer(ts)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	actual := Matrix{Principals: m.Principals, Routes: m.routes(), Expected: map[string]map[string]Outcome{}}
+	for _, route := range actual.Routes {
+		parts := strings.SplitN(route, " ", 2)
+		if len(parts) != 2 {
+			t.Fatalf("invalid route %q, expected `METHOD /path`", route)
+		}
+		actual.Expected[route] = map[string]Outcome{}
+		for _, p := range m.Principals {
+			req := client.NewRequest(parts[0], parts[1], nil)
+			if p.Authorization != "" {
+				req.Header.Set("Authorization", p.Authorization)
+			}
+			res := client.Do(req)
+			res.Body.Close()
+			actual.Expected[route][p.Name] = outcome(res.StatusCode)
+		}
+	}
+	return actual
+}
+
+// Run makes a request for every route and principal to the handler, and fails the
+// test with a grid of every outcome which differs from what's expected.
+func (m Matrix) Run(t *testing.T, handler http.Handler) {
+	t.Helper()
+	actual := m.Actual(t, handler)
+	if grid := m.mismatches(actual); grid != "" {
+		t.Errorf("permission matrix mismatches, as expected/actual:\n%s", grid)
+	}
+}
+
+func (m Matrix) mismatches(actual Matrix) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, "METHOD\tPATH")
+	for _, p := range m.Principals {
+		fmt.Fprint(w, "\t"+p.Name)
+	}
+	fmt.Fprintln(w)
+
+	found := false
+	for _, route := range actual.Routes {
+		var cells []string
+		mismatched := false
+		for _, p := range m.Principals {
+			expected, got := m.Expected[route][p.Name], actual.Expected[route][p.Name]
+			if expected == got {
+				cells = append(cells, ".")
+				continue
+			}
+			if expected == "" {
+				expected = unknown
+			}
+			cells = append(cells, string(expected)+"/"+string(got))
+			mismatched = true
+		}
+		if mismatched {
+			found = true
+			fmt.Fprintln(w, strings.Replace(route, " ", "\t", 1)+"\t"+strings.Join(cells, "\t"))
+		}
+	}
+	w.Flush()
+	if !found {
+		return ""
+	}
+	return buf.String()
+}
+
+// String formats the matrix as a grid, in the format of golden files.  Cells
+// without an expected outcome are written as `?`.
+func (m Matrix) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, "METHOD\tPATH")
+	for _, p := range m.Principals {
+		fmt.Fprint(w, "\t"+p.Name)
+	}
+	fmt.Fprintln(w)
+	for _, route := range m.routes() {
+		fmt.Fprint(w, strings.Replace(route, " ", "\t", 1))
+		for _, p := range m.Principals {
+			cell := m.Expected[route][p.Name]
+			if cell == "" {
+				cell = unknown
+			}
+			fmt.Fprint(w, "\t"+string(cell))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// RunGolden checks the routes against the expected matrix in a golden file, as
+// written by `Matrix.String`.  Running the tests with `-authtest.update` instead
+// writes the current outcomes to the golden file, so changes to permissions can be
+// reviewed as a diff.  Columns in the golden file are matched to principals by
+// name.  If routes is nil, the routes in the golden file are used.
+func RunGolden(t *testing.T, handler http.Handler, principals []Principal, routes []string, path string) {
+	t.Helper()
+	expected, err := LoadMatrix(path)
+	if err != nil && !(*update && os.IsNotExist(err)) {
+		t.Fatalf("%s (run with -authtest.update to create it)", err)
+	}
+	if routes == nil {
+		routes = expected.Routes
+	}
+	if len(routes) == 0 {
+		t.Fatal("no routes to check")
+	}
+
+	m := Matrix{Principals: principals, Routes: routes, Expected: expected.Expected}
+	if *update {
+		actual := m.Actual(t, handler)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(actual.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	m.Run(t, handler)
+}
+
+// LoadMatrix reads the expected outcomes in a golden file.  The principals of the
+// returned matrix only have names.
+func LoadMatrix(path string) (Matrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Matrix{}, err
+	}
+	defer f.Close()
+
+	m := Matrix{Expected: map[string]map[string]Outcome{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if m.Principals == nil {
+			if len(fields) < 2 || fields[0] != "METHOD" || fields[1] != "PATH" {
+				return Matrix{}, fmt.Errorf("%s:%d: expected a `METHOD PATH ...` header", path, line)
+			}
+			m.Principals = []Principal{}
+			for _, name := range fields[2:] {
+				m.Principals = append(m.Principals, Principal{Name: name})
+			}
+			continue
+		}
+		if len(fields) != len(m.Principals)+2 {
+			return Matrix{}, fmt.Errorf("%s:%d: expected %d columns, got %d", path, line, len(m.Principals)+2, len(fields))
+		}
+		route := fields[0] + " " + fields[1]
+		m.Routes = append(m.Routes, route)
+		m.Expected[route] = map[string]Outcome{}
+		for i, p := range m.Principals {
+			if cell := Outcome(fields[i+2]); cell != unknown {
+				m.Expected[route][p.Name] = cell
+			}
+		}
+	}
+	return m, scanner.Err()
+}
@@ -0,0 +1,128 @@
+package authtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/apikeyauth"
+)
+
+var principals = []Principal{
+	{"anonymous", ""},
+	{"reader", "Key reader-key"},
+	{"writer", "Key writer-key"},
+}
+
+func matrixApp() http.Handler {
+	authn := NewAuthenticator().
+		Add("reader-key", auth.NewBasicApiClient("reader", []string{"users.read"})).
+		Add("writer-key", auth.NewBasicApiClient("writer", []string{"users.read", "users.write"}))
+	authClient := auth.NewClientAuthorizer("ApiClient", auth.StandardErrorHandler)
+	authPerms := auth.NewPermissionsAuthorizer("ApiClient", auth.StandardErrorHandler)
+	h := http.HandlerFunc(appHandler)
+
+	router := mux.NewRouter()
+	router.Handle("/public", h).Methods("GET")
+	router.Handle("/private", authClient(h)).Methods("GET")
+	router.Handle("/private/users", authPerms(h, "users.read")).Methods("GET")
+	router.Handle("/private/users", authPerms(h, "users.read", "users.write")).Methods("POST")
+	return apikeyauth.NewAPIKeyAuthenticator("Key", "ApiClient", auth.StandardErrorHandler, authn.Authenticate)(router)
+}
+
+func TestMatrixRedirects(t *testing.T) {
+	// redirects to a login page aren't followed, so they don't look allowed
+	h := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login" && r.Header.Get("Authorization") == "" {
+			http.Redirect(rw, r, "/login", http.StatusFound)
+		}
+	})
+	Matrix{
+		Principals: principals[:2],
+		Expected: map[string]map[string]Outcome{
+			"GET /login":   {"anonymous": Allow, "reader": Allow},
+			"GET /private": {"anonymous": "302", "reader": Allow},
+		},
+	}.Run(t, h)
+}
+
+func TestMatrix(t *testing.T) {
+	m := Matrix{
+		Principals: principals,
+		Expected: map[string]map[string]Outcome{
+			"GET /public":         {"anonymous": Allow, "reader": Allow, "writer": Allow},
+			"GET /private":        {"anonymous": Unauthenticated, "reader": Allow, "writer": Allow},
+			"GET /private/users":  {"anonymous": Unauthenticated, "reader": Allow, "writer": Allow},
+			"POST /private/users": {"anonymous": Unauthenticated, "reader": Denied, "writer": Allow},
+		},
+	}
+	m.Run(t, matrixApp())
+
+	// mismatches are reported as a grid
+	m.Expected["POST /private/users"]["reader"] = Allow
+	delete(m.Expected["GET /public"], "writer")
+	require.Equal(t, ""+
+		"METHOD  PATH            anonymous  reader     writer\n"+
+		"GET     /public         .          .          ?/allow\n"+
+		"POST    /private/users  .          allow/403  .\n",
+		m.mismatches(m.Actual(t, matrixApp())))
+}
+
+func TestRunGolden(t *testing.T) {
+	RunGolden(t, matrixApp(), principals, nil, "testdata/matrix.golden")
+}
+
+func TestUpdateGolden(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authtest")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matrix.golden")
+
+	*update = true
+	defer func() { *update = false }()
+	RunGolden(t, matrixApp(), principals, []string{"GET /public", "POST /private/users"}, path)
+
+	out, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, ""+
+		"METHOD  PATH            anonymous  reader  writer\n"+
+		"GET     /public         allow      allow   allow\n"+
+		"POST    /private/users  401        403     allow\n", string(out))
+
+	m, err := LoadMatrix(path)
+	require.Nil(t, err)
+	require.Equal(t, []string{"GET /public", "POST /private/users"}, m.Routes)
+	require.Equal(t, Denied, m.Expected["POST /private/users"]["reader"])
+}
+
+func TestMatrixString(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authtest")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "matrix.golden")
+
+	// cells without an expected outcome round trip as `?`
+	m := Matrix{
+		Principals: principals,
+		Expected:   map[string]map[string]Outcome{"GET /public": {"anonymous": Allow, "writer": Allow}},
+	}
+	require.Equal(t, ""+
+		"METHOD  PATH     anonymous  reader  writer\n"+
+		"GET     /public  allow      ?       allow\n", m.String())
+	require.Nil(t, ioutil.WriteFile(path, []byte(m.String()), 0644))
+	loaded, err := LoadMatrix(path)
+	require.Nil(t, err)
+	require.Equal(t, m.Expected, loaded.Expected)
+
+	// names must survive being split into columns
+	require.Nil(t, m.validate())
+	for _, name := range []string{"", "read only", "tab\tname"} {
+		require.NotNil(t, Matrix{Principals: []Principal{{name, ""}}}.validate(), name)
+	}
+}
@@ -0,0 +1,401 @@
+// Package oauthtest provides a fake OAuth2 and OpenID Connect provider running on
+// an `httptest.Server`, so token based authentication can be tested without a
+// real provider.
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/oidc"
+	"github.com/globalprofessionalsearch/go-tools/http/jsonio"
+	"github.com/globalprofessionalsearch/go-tools/testing/webtest"
+)
+
+// Claims are the claims of a minted token.  Claims set to nil are removed from
+// the token, so defaults like `exp` can be left out.
+type Claims map[string]interface{}
+
+// Provider is a fake provider serving discovery, JWKS, authorize, token,
+// introspection and userinfo endpoints.  Tokens are signed JWTs, which are also
+// remembered so that they can be introspected.  Any internal errors will fail the
+// test.
+//
+// Example:
+//
+//	p := oauthtest.NewProvider(t)
+//	defer p.Close()
+//	p.AddClient("my-app", "secret")
+//	client := p.Client(t, ts, oauthtest.Claims{"sub": "user-1", "perms": []string{"users.read"}})
+//	res := client.Call("GET", "/private/users", nil)
+type Provider struct {
+	*httptest.Server
+
+	t       *testing.T
+	key     *rsa.PrivateKey
+	badKey  *rsa.PrivateKey
+	mu      sync.Mutex
+	clients map[string]string
+	user    Claims
+	tokens  map[string]Claims
+	codes   map[string]authorization
+}
+
+// authorization is a pending authorization code
+type authorization struct {
+	clientID, redirectURI, nonce string
+}
+
+// KeyID is the `kid` of the key the provider signs tokens with
+const KeyID = "oauthtest-key"
+
+// NewProvider starts a new provider.  It should be closed when the test is done.
+func NewProvider(t *testing.T) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{
+		t:       t,
+		key:     key,
+		badKey:  badKey,
+		clients: map[string]string{},
+		user:    Claims{"sub": "user"},
+		tokens:  map[string]Claims{},
+		codes:   map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/introspect", p.introspect)
+	mux.HandleFunc("/userinfo", p.userinfo)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer identifier of the provider, which is also its URL
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// KeysURL returns the URL of the provider's JWKS endpoint
+func (p *Provider) KeysURL() string {
+	return p.URL + "/keys"
+}
+
+// SigningMethod returns a method for verifying tokens signed by the provider
+func (p *Provider) SigningMethod() jwtauth.SigningMethod {
+	return jwtauth.RS256PublicKey(&p.key.PublicKey)
+}
+
+// AddClient registers a client which can authenticate to the token and
+// introspection endpoints
+func (p *Provider) AddClient(id, secret string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clients[id] = secret
+}
+
+// SetUser sets the claims of the user who is logged in when the authorize
+// endpoint is called
+func (p *Provider) SetUser(claims Claims) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = claims
+}
+
+// Token mints a token signed by the provider.  The `iss`, `iat` and `exp` claims
+// are set by default, expiring in an hour.
+func (p *Provider) Token(claims Claims) string {
+	token, err := p.mint(p.key, claims)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return token
+}
+
+// ExpiredToken mints a token signed by the provider which expired a minute ago
+func (p *Provider) ExpiredToken(claims Claims) string {
+	return p.Token(merge(claims, Claims{"exp": time.Now().Add(-time.Minute).Unix()}))
+}
+
+// BadlySignedToken mints a token which claims to be from the provider, but was
+// signed with a different key
+func (p *Provider) BadlySignedToken(claims Claims) string {
+	token, err := p.mint(p.badKey, claims)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return token
+}
+
+// Client returns a webtest client for the target server, which sends a token
+// minted with the claims in the `Authorization` header of every request.
+func (p *Provider) Client(t *testing.T, target *httptest.Server, claims Claims) *webtest.Client {
+	return webtest.NewClient(t).
+		SetTargetServer(target).
+		SetDefaultHeaders(map[string]string{"Authorization": "Bearer " + p.Token(claims)})
+}
+
+// mint signs a token, remembering its claims if signed with the provider's key.
+// It is also called from handlers, which can't fail the test directly.
+func (p *Provider) mint(key *rsa.PrivateKey, claims Claims) (string, error) {
+	now := time.Now()
+	all := merge(Claims{"iss": p.Issuer(), "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}, claims)
+	token, err := jwtauth.Encode(jwtauth.RS256(key), KeyID, all)
+	if err != nil {
+		return "", err
+	}
+	if key == p.key {
+		p.mu.Lock()
+		p.tokens[token] = all
+		p.mu.Unlock()
+	}
+	return token, nil
+}
+
+// merge returns the claims in a overridden by those in b, removing any set to nil
+func merge(a, b Claims) Claims {
+	out := Claims{}
+	for _, claims := range []Claims{a, b} {
+		for k, v := range claims {
+			if v == nil {
+				delete(out, k)
+				continue
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func (p *Provider) discovery(rw http.ResponseWriter, r *http.Request) {
+	jsonio.Respond(rw, 200, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"introspection_endpoint":                p.URL + "/introspect",
+		"userinfo_endpoint":                     p.URL + "/userinfo",
+		"jwks_uri":                              p.KeysURL(),
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *Provider) keys(rw http.ResponseWriter, r *http.Request) {
+	jsonio.Respond(rw, 200, jwtauth.JSONWebKeySet{
+		Keys: []jwtauth.JSONWebKey{jwtauth.NewRSAJSONWebKey(KeyID, &p.key.PublicKey)},
+	})
+}
+
+// authorize immediately approves the request for the current user, redirecting
+// back to the client with an authorization code
+func (p *Provider) authorize(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p.mu.Lock()
+	_, known := p.clients[q.Get("client_id")]
+	p.mu.Unlock()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if !known || err != nil || q.Get("response_type") != "code" {
+		jsonio.Respond(rw, 400, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		p.internalError(rw, err)
+		return
+	}
+	p.mu.Lock()
+	p.codes[code] = authorization{q.Get("client_id"), q.Get("redirect_uri"), q.Get("nonce")}
+	p.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirect.RawQuery = params.Encode()
+	http.Redirect(rw, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(rw http.ResponseWriter, r *http.Request) {
+	clientID, ok := p.authenticateClient(r)
+	if !ok {
+		jsonio.Respond(rw, 401, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "client_credentials":
+		claims := Claims{"sub": clientID, "client_id": clientID}
+		if scope := r.PostFormValue("scope"); scope != "" {
+			claims["scope"] = scope
+			claims["perms"] = strings.Fields(scope)
+		}
+		p.respondTokens(rw, claims, nil, nil)
+
+	case "authorization_code":
+		p.mu.Lock()
+		authz, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		user := p.user
+		p.mu.Unlock()
+		if !ok || authz.clientID != clientID || authz.redirectURI != r.PostFormValue("redirect_uri") {
+			jsonio.Respond(rw, 400, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idClaims := merge(user, Claims{"aud": clientID})
+		if authz.nonce != "" {
+			idClaims["nonce"] = authz.nonce
+		}
+		p.respondTokens(rw,
+			merge(user, Claims{"client_id": clientID}),
+			merge(user, Claims{"client_id": clientID, "use": "refresh", "exp": time.Now().Add(24 * time.Hour).Unix()}),
+			idClaims,
+		)
+
+	case "refresh_token":
+		claims, ok := p.lookup(r.PostFormValue("refresh_token"))
+		if !ok || claims["use"] != "refresh" || claims["client_id"] != clientID {
+			jsonio.Respond(rw, 400, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		p.respondTokens(rw, merge(claims, Claims{"use": nil, "exp": nil, "iat": nil}), nil, nil)
+
+	default:
+		jsonio.Respond(rw, 400, map[string]string{"error": "unsupported_grant_type"})
+	}
+}
+
+// respondTokens mints and responds with an access token, and refresh and ID
+// tokens if their claims are not nil.  The `at_hash` claim of the ID token is
+// set from the access token.
+func (p *Provider) respondTokens(rw http.ResponseWriter, access, refresh, id Claims) {
+	accessToken, err := p.mint(p.key, access)
+	if err != nil {
+		p.internalError(rw, err)
+		return
+	}
+	res := map[string]interface{}{"access_token": accessToken, "token_type": "Bearer", "expires_in": 3600}
+
+	if refresh != nil {
+		if res["refresh_token"], err = p.mint(p.key, refresh); err != nil {
+			p.internalError(rw, err)
+			return
+		}
+	}
+	if id != nil {
+		if res["id_token"], err = p.mint(p.key, merge(id, Claims{"at_hash": oidc.AccessTokenHash(accessToken)})); err != nil {
+			p.internalError(rw, err)
+			return
+		}
+	}
+	jsonio.Respond(rw, 200, res)
+}
+
+func (p *Provider) introspect(rw http.ResponseWriter, r *http.Request) {
+	if _, ok := p.authenticateClient(r); !ok {
+		jsonio.Respond(rw, 401, map[string]string{"error": "invalid_client"})
+		return
+	}
+	claims, ok := p.lookup(r.PostFormValue("token"))
+	if !ok {
+		jsonio.Respond(rw, 200, map[string]bool{"active": false})
+		return
+	}
+	jsonio.Respond(rw, 200, merge(claims, Claims{"active": true}))
+}
+
+func (p *Provider) userinfo(rw http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		rw.WriteHeader(401)
+		return
+	}
+	claims, ok := p.lookup(parts[1])
+	if !ok {
+		rw.WriteHeader(401)
+		return
+	}
+	jsonio.Respond(rw, 200, merge(claims, Claims{"iss": nil, "iat": nil, "exp": nil, "aud": nil, "client_id": nil, "scope": nil, "perms": nil}))
+}
+
+// lookup returns the claims of a token minted by the provider, if it is not
+// expired
+func (p *Provider) lookup(token string) (Claims, bool) {
+	p.mu.Lock()
+	claims, ok := p.tokens[token]
+	p.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if expired(claims) {
+		return nil, false
+	}
+	return claims, true
+}
+
+// authenticateClient checks the client credentials sent with basic auth, or in
+// the form body
+func (p *Provider) authenticateClient(r *http.Request) (string, bool) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expected, known := p.clients[id]
+	return id, known && expected == secret
+}
+
+// internalError reports an error encountered by a handler, which can't fail the
+// test directly as it isn't running in the test goroutine
+func (p *Provider) internalError(rw http.ResponseWriter, err error) {
+	p.t.Error(err)
+	jsonio.Respond(rw, 500, map[string]string{"error": "server_error"})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// expired returns whether or not the `exp` claim is in the past
+func expired(claims Claims) bool {
+	var exp int64
+	switch v := claims["exp"].(type) {
+	case int64:
+		exp = v
+	case int:
+		exp = int64(v)
+	case float64:
+		exp = int64(v)
+	default:
+		return false
+	}
+	return time.Now().Unix() >= exp
+}
@@ -0,0 +1,137 @@
+package oauthtest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/authtransport"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/jwtauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/oauth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/oidc"
+	"github.com/globalprofessionalsearch/go-tools/testing/webtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintedTokens(t *testing.T) {
+	p := NewProvider(t)
+	defer p.Close()
+	verifier := &jwtauth.Verifier{KeyFunc: jwtauth.NewKeySet(p.KeysURL()).KeyFunc, Issuer: p.Issuer()}
+
+	claims, err := verifier.Verify(p.Token(Claims{"sub": "user-1", "perms": []string{"users.read"}}))
+	require.Nil(t, err)
+	require.Equal(t, "user-1", claims.Subject)
+	require.Equal(t, []string{"users.read"}, claims.Permissions)
+
+	_, err = verifier.Verify(p.ExpiredToken(Claims{"sub": "user-1"}))
+	require.Equal(t, jwtauth.ErrTokenExpired, err)
+	_, err = verifier.Verify(p.BadlySignedToken(Claims{"sub": "user-1"}))
+	require.Equal(t, jwtauth.ErrInvalidToken, err)
+	_, err = verifier.Verify(p.Token(Claims{"sub": "user-1", "iss": "https://evil.example.com"}))
+	require.Equal(t, jwtauth.ErrInvalidToken, err)
+
+	// tokens can be made without an expiry
+	claims, err = verifier.Verify(p.Token(Claims{"sub": "user-1", "exp": nil}))
+	require.Nil(t, err)
+	require.Equal(t, int64(0), claims.ExpiresAt)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	p := NewProvider(t)
+	defer p.Close()
+	p.AddClient("my-app", "secret")
+	p.SetUser(Claims{"sub": "user-1", "email": "user@example.com", "groups": []string{"admins"}})
+
+	provider, err := oidc.NewProvider(p.Issuer(), "my-app", nil)
+	require.Nil(t, err)
+
+	// authorize, without following the redirect back to the app
+	client := webtest.NewClient(t).SetTargetServer(p.Server)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	authorizeURL := provider.Metadata.AuthorizationEndpoint + "?" + url.Values{
+		"client_id":     {"my-app"},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"response_type": {"code"},
+		"state":         {"s-1"},
+		"nonce":         {"n-1"},
+	}.Encode()
+	res := client.Call("GET", strings.TrimPrefix(authorizeURL, p.URL), nil)
+	require.Equal(t, 302, res.StatusCode)
+	redirect, _ := url.Parse(res.Header.Get("Location"))
+	require.Equal(t, "s-1", redirect.Query().Get("state"))
+
+	// exchange the code
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {redirect.Query().Get("code")},
+		"redirect_uri": {"https://app.example.com/callback"},
+	}
+	req := client.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("my-app", "secret")
+	res = client.Do(req)
+	require.Equal(t, 200, res.StatusCode)
+	webtest.UnmarshalJsonResponse(t, res, &tokens)
+
+	id, err := provider.VerifyIDToken(tokens.IDToken, "n-1", tokens.AccessToken)
+	require.Nil(t, err)
+	require.Equal(t, "user-1", id.Subject)
+	require.Equal(t, []string{"admins"}, id.Groups)
+
+	info, err := provider.UserInfo(tokens.AccessToken, "user-1")
+	require.Nil(t, err)
+	require.Equal(t, "user@example.com", info.Email)
+
+	// codes can only be used once
+	req = client.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("my-app", "secret")
+	require.Equal(t, 400, client.Do(req).StatusCode)
+
+	// refresh tokens can be exchanged
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "client_id": {"my-app"}, "client_secret": {"secret"}}
+	req = client.NewRequest("POST", "/token", strings.NewReader(refresh.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	require.Equal(t, 200, client.Do(req).StatusCode)
+}
+
+func TestClientCredentialsAndIntrospection(t *testing.T) {
+	p := NewProvider(t)
+	defer p.Close()
+	p.AddClient("caller", "caller-secret")
+	p.AddClient("resource-server", "rs-secret")
+
+	creds := &authtransport.ClientCredentials{
+		TokenURL:     p.URL + "/token",
+		ClientID:     "caller",
+		ClientSecret: "caller-secret",
+		Scopes:       []string{"users.read"},
+	}
+	token, err := creds.Token()
+	require.Nil(t, err)
+
+	introspector := oauth.NewIntrospector(p.URL+"/introspect", "resource-server", "rs-secret")
+	obj, err := introspector.Authenticate(token)
+	require.Nil(t, err)
+	require.Equal(t, "caller", obj.(auth.Authenticator).AuthenticationID())
+	allowed, _ := obj.(auth.Authorizer).HasPermission("users.read")
+	require.True(t, allowed)
+
+	_, err = introspector.Authenticate(p.BadlySignedToken(Claims{"sub": "caller"}))
+	require.Equal(t, auth.ErrAuthenticationRequired, err)
+	_, err = introspector.Authenticate(p.Token(Claims{"sub": "caller", "exp": time.Now().Add(-time.Second).Unix()}))
+	require.Equal(t, auth.ErrAuthenticationRequired, err)
+
+	creds.ClientSecret = "wrong"
+	creds.Expire()
+	_, err = creds.Token()
+	require.NotNil(t, err)
+}
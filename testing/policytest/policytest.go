@@ -0,0 +1,80 @@
+// Package policytest provides a harness for asserting the outcomes of policy rules
+// against fixture requests, so policies can be tested like code.
+package policytest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth/policy"
+)
+
+// Fixture is a request, and the outcome the policy is expected to decide for it.
+// The input is either given directly, or built by the engine from a request and
+// principal.
+type Fixture struct {
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+	// Input rules are evaluated against
+	Input *policy.Input `json:"input,omitempty"`
+	// Request and Principal the input is built from, if Input isn't set
+	Request   *http.Request `json:"-"`
+	Principal interface{}   `json:"-"`
+	// Allow is the expected outcome
+	Allow bool `json:"allow"`
+	// Rule optionally asserts which rule decided the outcome
+	Rule string `json:"rule,omitempty"`
+}
+
+// LoadFixtures reads a JSON file containing a list of fixtures with inputs
+func LoadFixtures(t *testing.T, path string) []Fixture {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+	return fixtures
+}
+
+// Run evaluates each fixture against the engine's policy in a subtest, failing
+// those whose outcome differs from what's expected.
+func Run(t *testing.T, engine *policy.Engine, fixtures []Fixture) {
+	p := engine.Policy()
+	if p == nil {
+		t.Fatal("engine has no policy")
+	}
+	for _, f := range fixtures {
+		f := f
+		t.Run(f.Name, func(t *testing.T) {
+			input, err := fixtureInput(engine, f)
+			if err != nil {
+				t.Fatalf("building input: %s", err)
+			}
+			decision, err := p.Evaluate(input, f.Permission)
+			if err != nil {
+				t.Fatalf("evaluating %s: %s", f.Permission, err)
+			}
+			if decision.Allowed != f.Allow {
+				t.Errorf("%s: expected allow=%t, got allow=%t (rule %q)", f.Permission, f.Allow, decision.Allowed, decision.Rule)
+			}
+			if f.Rule != "" && decision.Rule != f.Rule {
+				t.Errorf("%s: expected decision by rule %q, got %q", f.Permission, f.Rule, decision.Rule)
+			}
+		})
+	}
+}
+
+func fixtureInput(engine *policy.Engine, f Fixture) (policy.Input, error) {
+	if f.Input != nil {
+		return *f.Input, nil
+	}
+	if f.Request == nil {
+		return policy.Input{}, nil
+	}
+	return engine.Input(f.Request, f.Principal)
+}
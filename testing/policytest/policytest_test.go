@@ -0,0 +1,41 @@
+package policytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globalprofessionalsearch/go-tools/http/auth"
+	"github.com/globalprofessionalsearch/go-tools/http/auth/policy"
+)
+
+const testPolicy = `{"rules": [
+	{"name": "edit own profile", "effect": "allow", "permissions": ["users.write"], "when": "principal.id == request.vars.id"},
+	{"name": "no offsite writes", "effect": "deny", "permissions": ["users.write"], "when": "!startsWith(request.ip, '10.')"}
+]}`
+
+func TestRun(t *testing.T) {
+	p, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := policy.NewEngine(p)
+	engine.PathVars = func(r *http.Request) map[string]string {
+		return map[string]string{"id": strings.TrimPrefix(r.URL.Path, "/users/")}
+	}
+
+	fixtures := LoadFixtures(t, "testdata/fixtures.json")
+
+	r := httptest.NewRequest("PUT", "http://example.com/users/user-1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	fixtures = append(fixtures, Fixture{
+		Name:       "fixture requests",
+		Permission: "users.write",
+		Request:    r,
+		Principal:  auth.NewBasicApiClient("user-1", nil),
+		Allow:      true,
+	})
+
+	Run(t, engine, fixtures)
+}